@@ -11,6 +11,8 @@ import (
 
 	"github.com/compose-spec/compose-go/cli"
 	"github.com/compose-spec/compose-go/types"
+	"github.com/foundriesio/compose-publish/internal"
+	"github.com/foundriesio/compose-publish/pkg/events"
 	"github.com/foundriesio/compose-publish/pkg/fioapp"
 )
 
@@ -18,15 +20,21 @@ func main() {
 	var composeFile string
 	var appRef string
 	var archListStr string
+	var compatProfile string
 
 	flag.StringVar(&composeFile, "compose-file", "docker-compose.yml", "A path to a compose file")
 	flag.StringVar(&appRef, "app-ref", "", "A reference to App's Registry Repo")
 	flag.StringVar(&archListStr, "arch-list", "", "An architecture list")
+	flag.StringVar(&compatProfile, "compat-profile", internal.DefaultCompatProfile, "A device compatibility profile")
 	flag.Parse()
 
 	if len(appRef) == 0 {
 		log.Fatalf("mandatory parameter `app-ref` is not defined")
 	}
+	profile, err := internal.GetCompatProfile(compatProfile)
+	if err != nil {
+		log.Fatalf("%s", err.Error())
+	}
 
 	appProj, err := getAppProject(composeFile)
 	if err != nil {
@@ -60,7 +68,7 @@ func main() {
 		}
 	}
 
-	layerManifests, err := fioapp.PostAppLayersManifests(ctx, appRef, appLayers, false)
+	layerManifests, err := fioapp.PostAppLayersManifests(ctx, appRef, appLayers, profile.PlatformOS, false, &events.Console{})
 	if err != nil {
 		log.Fatalf("failed to generate or post App layers manifest: %s", err.Error())
 	}
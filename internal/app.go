@@ -0,0 +1,226 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// AppManifest is the App manifest as it is pushed by CreateApp, i.e. an OCI
+// image manifest extended with the list of the App layers manifests. The list
+// is moved to a layers index blob if it doesn't fit into the manifest.
+type AppManifest struct {
+	ocischema.Manifest
+	Manifests []distribution.Descriptor `json:"manifests,omitempty"`
+}
+
+// LayersIndexDescriptor returns the descriptor of the blob listing the App
+// layers manifests, or nil if they are listed in the App manifest itself
+func (m *AppManifest) LayersIndexDescriptor() *distribution.Descriptor {
+	for _, l := range m.Layers {
+		if _, ok := l.Annotations["layers-index"]; ok {
+			return &l
+		}
+	}
+	return nil
+}
+
+// ParseLayersIndex returns the App layers manifests listed in a layers index blob
+func ParseLayersIndex(b []byte) ([]distribution.Descriptor, error) {
+	var index struct {
+		Manifests []distribution.Descriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse app layers index: %s", err)
+	}
+	return index.Manifests, nil
+}
+
+// AppPlatforms is the content of the App platforms blob, the platforms are
+// in the `os/arch[/variant]` form
+type AppPlatforms struct {
+	// Platforms are the platforms the App supports, i.e. the ones all the
+	// service images are available for
+	Platforms []string `json:"platforms"`
+	// Services maps services to the platforms their images are available for
+	Services map[string][]string `json:"services"`
+}
+
+// LayersManifest is a per-architecture App layers manifest as it is composed
+// by fioapp.ComposeAppLayersManifest
+type LayersManifest struct {
+	Platform    *v1.Platform              `json:"platform"`
+	Layers      []distribution.Descriptor `json:"layers"`
+	Annotations map[string]string         `json:"annotations"`
+}
+
+// PublishedApp is an App manifest fetched from a registry along with the
+// repository it was fetched from
+type PublishedApp struct {
+	Ref      reference.Named
+	Repo     distribution.Repository
+	Digest   digest.Digest
+	Raw      []byte
+	Manifest AppManifest
+}
+
+func GetPublishedApp(ctx context.Context, target string) (*PublishedApp, error) {
+	named, err := reference.ParseNormalizedNamed(target)
+	if err != nil {
+		return nil, err
+	}
+
+	regc := NewRegistryClient()
+	repo, err := regc.GetRepository(ctx, named)
+	if err != nil {
+		return nil, err
+	}
+
+	var dgst digest.Digest
+	if digested, ok := named.(reference.Digested); ok {
+		dgst = digested.Digest()
+	} else {
+		tag := "latest"
+		if tagged, ok := reference.TagNameOnly(named).(reference.Tagged); ok {
+			tag = tagged.Tag()
+		}
+		desc, err := repo.Tags(ctx).Get(ctx, tag)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to find app reference(%s): %s", target, err)
+		}
+		dgst = desc.Digest
+	}
+
+	mansvc, err := repo.Manifests(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	man, err := mansvc.Get(ctx, dgst)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get app manifest(%s): %s", target, err)
+	}
+	if _, ok := man.(*ocischema.DeserializedManifest); !ok {
+		return nil, fmt.Errorf("invalid app manifest type, expected *ocischema.DeserializedManifest, got: %T", man)
+	}
+	_, raw, err := man.Payload()
+	if err != nil {
+		return nil, err
+	}
+	if d := digest.FromBytes(raw); d != dgst {
+		return nil, fmt.Errorf("app manifest digest mismatch; expected: %s, got: %s", dgst, d)
+	}
+
+	app := &PublishedApp{
+		Ref:    named,
+		Repo:   repo,
+		Digest: dgst,
+		Raw:    raw,
+	}
+	if err := json.Unmarshal(raw, &app.Manifest); err != nil {
+		return nil, err
+	}
+	if app.Manifest.Annotations["compose-app"] != "v1" {
+		return nil, fmt.Errorf("manifest %s is not a compose app manifest", dgst)
+	}
+	return app, nil
+}
+
+// BundleMediaType is the media type of the App's tgz bundle blob
+const BundleMediaType = "application/tar+gzip"
+
+// BundleDescriptor returns the descriptor of the App's tgz bundle blob
+func (a *PublishedApp) BundleDescriptor() (distribution.Descriptor, error) {
+	for _, l := range a.Manifest.Layers {
+		if l.MediaType == BundleMediaType {
+			return l, nil
+		}
+	}
+	return distribution.Descriptor{}, errors.New("app manifest doesn't reference an app bundle")
+}
+
+// LayersMetaDescriptor returns the descriptor of the App's layers metadata
+// blob, or nil if the App was published without it
+func (a *PublishedApp) LayersMetaDescriptor() *distribution.Descriptor {
+	for _, l := range a.Manifest.Layers {
+		if _, ok := l.Annotations["layers-meta"]; ok {
+			return &l
+		}
+	}
+	return nil
+}
+
+// PlatformsDescriptor returns the descriptor of the App's platforms blob, or
+// nil if the App was published without it
+func (a *PublishedApp) PlatformsDescriptor() *distribution.Descriptor {
+	for _, l := range a.Manifest.Layers {
+		if _, ok := l.Annotations["platforms"]; ok {
+			return &l
+		}
+	}
+	return nil
+}
+
+// FetchBlob downloads a blob referenced by the App manifest and makes sure
+// its size and digest match the descriptor
+func (a *PublishedApp) FetchBlob(ctx context.Context, desc distribution.Descriptor) ([]byte, error) {
+	b, err := a.Repo.Blobs(ctx).Get(ctx, desc.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to fetch blob(%s): %s", desc.Digest, err)
+	}
+	if int64(len(b)) != desc.Size {
+		return nil, fmt.Errorf("blob %s size mismatch; expected: %d, got: %d", desc.Digest, desc.Size, len(b))
+	}
+	if d := digest.FromBytes(b); d != desc.Digest {
+		return nil, fmt.Errorf("blob digest mismatch; expected: %s, got: %s", desc.Digest, d)
+	}
+	return b, nil
+}
+
+// LayersManifests returns the descriptors of the App layers manifests, they
+// are fetched from the layers index blob if the App manifest refers to one
+func (a *PublishedApp) LayersManifests(ctx context.Context) ([]distribution.Descriptor, error) {
+	desc := a.Manifest.LayersIndexDescriptor()
+	if desc == nil {
+		return a.Manifest.Manifests, nil
+	}
+	b, err := a.FetchBlob(ctx, *desc)
+	if err != nil {
+		return nil, err
+	}
+	return ParseLayersIndex(b)
+}
+
+// FetchLayersManifest downloads one of the App layers manifests referenced
+// by the App manifest
+func (a *PublishedApp) FetchLayersManifest(ctx context.Context, desc distribution.Descriptor) (*LayersManifest, error) {
+	mansvc, err := a.Repo.Manifests(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	man, err := mansvc.Get(ctx, desc.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get app layers manifest(%s): %s", desc.Digest, err)
+	}
+	_, raw, err := man.Payload()
+	if err != nil {
+		return nil, err
+	}
+	if d := digest.FromBytes(raw); d != desc.Digest {
+		return nil, fmt.Errorf("app layers manifest digest mismatch; expected: %s, got: %s", desc.Digest, d)
+	}
+	if int64(len(raw)) != desc.Size {
+		return nil, fmt.Errorf("app layers manifest %s size mismatch; expected: %d, got: %d", desc.Digest, desc.Size, len(raw))
+	}
+	var layersMan LayersManifest
+	if err := json.Unmarshal(raw, &layersMan); err != nil {
+		return nil, err
+	}
+	return &layersMan, nil
+}
@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/foundriesio/compose-publish/pkg/events"
+)
+
+// App manifest size reductions in the order they are applied
+const (
+	// ReductionCompactJSON serializes the App manifest without indentation
+	ReductionCompactJSON = "compact-json"
+	// ReductionOptionalFields drops the descriptor fields of the App layers
+	// manifests that aklite doesn't use, i.e. everything except of the
+	// media type, digest, size, and platform architecture and variant
+	ReductionOptionalFields = "drop-optional-fields"
+	// ReductionLayersIndex moves the list of the App layers manifests to
+	// an index blob referenced by the App manifest
+	ReductionLayersIndex = "layers-index"
+)
+
+// appManifestDef is an App manifest before it is serialized
+type appManifestDef struct {
+	// blobs are the config followed by the layers of the App manifest
+	blobs     []appBlob
+	manifests []distribution.Descriptor
+	compact   bool
+	// indexed is set if the App layers manifests are listed in an index blob
+	indexed bool
+}
+
+type sizeReduction struct {
+	name string
+	// allowed tells whether the reduction is supported by the devices of a
+	// compatibility profile and makes the manifest of the profile smaller
+	allowed func(CompatProfile) bool
+	apply   func(*appManifestDef) error
+}
+
+var sizeReductions = []sizeReduction{
+	{ReductionCompactJSON, func(CompatProfile) bool { return true }, func(a *appManifestDef) error {
+		a.compact = true
+		return nil
+	}},
+	// the platform OS is the only optional field set by the App layers manifests' descriptors
+	{ReductionOptionalFields, func(p CompatProfile) bool { return len(p.PlatformOS) > 0 }, dropOptionalFields},
+	{ReductionLayersIndex, func(p CompatProfile) bool { return p.LayersIndex }, moveManifestsToIndex},
+}
+
+func (a *appManifestDef) build() (*ocischema.DeserializedManifest, []byte, error) {
+	var layers []distribution.Descriptor
+	for _, b := range a.blobs[1:] {
+		layers = append(layers, b.desc)
+	}
+	man, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned:   ocischema.SchemaVersion,
+		Config:      a.blobs[0].desc,
+		Layers:      layers,
+		Annotations: map[string]string{"compose-app": "v1"},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b, err := man.MarshalJSON()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	manMap := make(map[string]interface{})
+	err = json.Unmarshal(b, &manMap)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !a.indexed {
+		manMap["manifests"] = a.manifests
+	}
+
+	var b1 []byte
+	if a.compact {
+		b1, err = json.Marshal(manMap)
+	} else {
+		b1, err = json.MarshalIndent(manMap, "", "   ")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = man.UnmarshalJSON(b1)
+	if err != nil {
+		return nil, nil, err
+	}
+	return man, b1, nil
+}
+
+func dropOptionalFields(a *appManifestDef) error {
+	manifests := make([]distribution.Descriptor, 0, len(a.manifests))
+	for _, m := range a.manifests {
+		desc := distribution.Descriptor{MediaType: m.MediaType, Digest: m.Digest, Size: m.Size}
+		if m.Platform != nil {
+			desc.Platform = &v1.Platform{Architecture: m.Platform.Architecture, Variant: m.Platform.Variant}
+		}
+		manifests = append(manifests, desc)
+	}
+	a.manifests = manifests
+	return nil
+}
+
+func moveManifestsToIndex(a *appManifestDef) error {
+	index := struct {
+		manifest.Versioned
+		Manifests []distribution.Descriptor `json:"manifests"`
+	}{
+		Versioned: manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageIndex},
+		Manifests: a.manifests,
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	a.blobs = append(a.blobs, appBlob{
+		kind: events.BlobLayersIndex,
+		desc: distribution.Descriptor{
+			MediaType:   v1.MediaTypeImageIndex,
+			Digest:      digest.FromBytes(data),
+			Size:        int64(len(data)),
+			Annotations: map[string]string{"layers-index": "v1"},
+		},
+		data: data,
+	})
+	a.indexed = true
+	return nil
+}
+
+// manifestSizeBreakdown tells how many bytes each of the App manifest
+// fields takes, the rest is taken by the keys and whitespace
+func manifestSizeBreakdown(b []byte) (string, error) {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	rest := len(b)
+	for _, k := range keys {
+		part := fmt.Sprintf("%s: %d", k, len(fields[k]))
+		var entries []json.RawMessage
+		if json.Unmarshal(fields[k], &entries) == nil && len(entries) > 0 {
+			part += fmt.Sprintf(" (%d entries)", len(entries))
+		}
+		parts = append(parts, part)
+		rest -= len(fields[k])
+	}
+	parts = append(parts, fmt.Sprintf("other: %d", rest))
+	return "bytes by field: " + strings.Join(parts, ", "), nil
+}
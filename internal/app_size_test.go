@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/foundriesio/compose-publish/pkg/events"
+)
+
+func TestComposeAppReductions(t *testing.T) {
+	manifests := func(n int, os string) []distribution.Descriptor {
+		var descs []distribution.Descriptor
+		for i := 0; i < n; i++ {
+			descs = append(descs, distribution.Descriptor{
+				MediaType: v1.MediaTypeImageIndex,
+				Digest:    digest.FromBytes([]byte{byte(i)}),
+				Size:      1000,
+				Platform:  &v1.Platform{Architecture: "arch", Variant: string(rune('a' + i)), OS: os},
+			})
+		}
+		return descs
+	}
+
+	tests := []struct {
+		name       string
+		profile    string
+		manifests  []distribution.Descriptor
+		reductions []string
+		fails      bool
+	}{
+		{
+			name:      "legacy manifest that fits",
+			profile:   "aklite-legacy",
+			manifests: manifests(2, ""),
+		},
+		{
+			name:       "legacy manifest is only compacted",
+			profile:    "aklite-legacy",
+			manifests:  manifests(6, ""),
+			reductions: []string{ReductionCompactJSON},
+		},
+		{
+			name:       "legacy manifest is not moved to a layers index",
+			profile:    "aklite-legacy",
+			manifests:  manifests(12, ""),
+			reductions: []string{ReductionCompactJSON},
+			fails:      true,
+		},
+		{
+			name:       "16k manifest drops the platform os",
+			profile:    "aklite-16k",
+			manifests:  manifests(76, "LMP"),
+			reductions: []string{ReductionCompactJSON, ReductionOptionalFields},
+		},
+		{
+			name:       "16k manifest is moved to a layers index",
+			profile:    "aklite-16k",
+			manifests:  manifests(100, "LMP"),
+			reductions: []string{ReductionCompactJSON, ReductionOptionalFields, ReductionLayersIndex},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			profile, err := GetCompatProfile(tc.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var built *events.ManifestBuilt
+			sink := events.SinkFunc(func(e events.Event) {
+				if b, ok := e.(events.ManifestBuilt); ok {
+					built = &b
+				}
+			})
+			_, blobs, err := composeApp([]byte("compose"), []byte("bundle"), tc.manifests, nil, nil, profile, true, sink)
+			if tc.fails != (err != nil) {
+				t.Fatalf("unexpected result: %v", err)
+			}
+			if built == nil {
+				t.Fatal("the manifest is not reported")
+			}
+			if !reflect.DeepEqual(built.Reductions, tc.reductions) {
+				t.Errorf("unexpected reductions; expected: %q, got: %q", tc.reductions, built.Reductions)
+			}
+			if err == nil && built.Size >= profile.MaxManifestBodySize {
+				t.Errorf("manifest size %d exceeds the limit %d", built.Size, profile.MaxManifestBodySize)
+			}
+			for _, b := range blobs {
+				if b.kind == events.BlobLayersIndex && !profile.LayersIndex {
+					t.Errorf("a layers index is added for the %s profile", profile.Name)
+				}
+			}
+		})
+	}
+}
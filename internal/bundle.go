@@ -0,0 +1,262 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// CreateBundle creates an App bundle out of the pinned compose config and the
+// content of the App directory, it returns the pinned compose file content
+// and the bundle. The compose files the config was merged from are left out
+// of the bundle, except docker-compose.yml that is replaced with the config.
+func CreateBundle(config map[string]interface{}, appDir string, composeFiles []string) ([]byte, []byte, error) {
+	pinned, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	absDir, err := filepath.Abs(appDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var excludes []string
+	for _, f := range composeFiles {
+		absFile, err := filepath.Abs(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		rel, err := filepath.Rel(absDir, absFile)
+		if err != nil || rel == "docker-compose.yml" || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		excludes = append(excludes, rel)
+	}
+	bundle, err := createTgz(pinned, appDir, excludes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pinned, bundle, nil
+}
+
+// ReadBundle returns content of the regular files of an App bundle keyed by
+// their path within the bundle
+func ReadBundle(bundle []byte) (map[string][]byte, error) {
+	gzr, err := gzip.NewReader(bytes.NewReader(bundle))
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read %s: %s", hdr.Name, err)
+		}
+		files[filepath.Clean(hdr.Name)] = b
+	}
+	return files, nil
+}
+
+// securePath returns the path an archive entry should be extracted to, it
+// fails if the entry would end up outside of the destination directory
+func securePath(dstDir, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("archive entry has an absolute path: %s", name)
+	}
+	p := filepath.Join(dstDir, name)
+	if !isWithin(dstDir, p) {
+		return "", fmt.Errorf("archive entry points outside of the destination directory: %s", name)
+	}
+	if p == dstDir {
+		return p, nil
+	}
+	// a symlink extracted earlier may redirect the entry outside of the
+	// destination directory, so the entry must not go through any of them
+	rel, err := filepath.Rel(dstDir, filepath.Dir(p))
+	if err != nil {
+		return "", err
+	}
+	cur := dstDir
+	for _, c := range strings.Split(rel, string(os.PathSeparator)) {
+		if c == "." {
+			continue
+		}
+		cur = filepath.Join(cur, c)
+		fi, err := os.Lstat(cur)
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("archive entry goes through a symlink: %s", name)
+		}
+	}
+	return p, nil
+}
+
+func isWithin(dir, p string) bool {
+	return p == dir || strings.HasPrefix(p, dir+string(os.PathSeparator))
+}
+
+// linkResolvesWithin reports whether the target of a symlink in dir stays
+// within root once it's resolved through the symlinks extracted so far. The
+// target is not resolved lexically, since `a/l/..` is not `a` if `a/l` is a
+// symlink, and `..` after a component that doesn't exist yet is rejected, as
+// the component may be extracted as a symlink later.
+func linkResolvesWithin(root, dir, target string) (bool, error) {
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return false, err
+	}
+	cur, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false, err
+	}
+	pending := false
+	for _, c := range strings.Split(target, string(os.PathSeparator)) {
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			if pending {
+				return false, nil
+			}
+			cur = filepath.Dir(cur)
+		default:
+			cur = filepath.Join(cur, c)
+			if pending {
+				continue
+			}
+			fi, err := os.Lstat(cur)
+			if os.IsNotExist(err) {
+				pending = true
+				continue
+			} else if err != nil {
+				return false, err
+			}
+			if fi.Mode()&os.ModeSymlink != 0 {
+				// a dangling symlink may be completed by a later entry
+				if cur, err = filepath.EvalSymlinks(cur); err != nil {
+					return false, nil
+				}
+			}
+		}
+		if !isWithin(root, cur) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// ExtractBundle extracts an App bundle produced by createTgz into dstDir
+func ExtractBundle(bundle []byte, dstDir string) error {
+	dstDir, err := filepath.Abs(dstDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dstDir, 0o755); err != nil {
+		return err
+	}
+
+	gzr, err := gzip.NewReader(bytes.NewReader(bundle))
+	if err != nil {
+		return err
+	}
+	defer gzr.Close()
+
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		path, err := securePath(dstDir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, mode|0o700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			// don't write through a symlink the entry replaces
+			if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+				if err := os.Remove(path); err != nil {
+					return err
+				}
+			}
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return fmt.Errorf("Unable to extract %s: %s", hdr.Name, err)
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			within := false
+			if !filepath.IsAbs(hdr.Linkname) {
+				if within, err = linkResolvesWithin(dstDir, filepath.Dir(path), hdr.Linkname); err != nil {
+					return err
+				}
+			}
+			if !within {
+				return fmt.Errorf("archive symlink points outside of the destination directory: %s -> %s", hdr.Name, hdr.Linkname)
+			}
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			target, err := securePath(dstDir, hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			if err := os.Link(target, path); err != nil {
+				return err
+			}
+		default:
+			fmt.Printf("  |-> skipping unsupported archive entry: %s\n", hdr.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tgz(t *testing.T, hdrs []*tar.Header) []byte {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	for _, hdr := range hdrs {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write(make([]byte, hdr.Size)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	tw.Close()
+	gzw.Close()
+	return buf.Bytes()
+}
+
+func TestExtractBundleSymlinkTraversal(t *testing.T) {
+	root, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dst := filepath.Join(root, "a", "dst")
+
+	bundle := tgz(t, []*tar.Header{
+		{Name: "d/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "d/l", Typeflag: tar.TypeSymlink, Linkname: ".."},
+		{Name: "d/l/x", Typeflag: tar.TypeSymlink, Linkname: ".."},
+		{Name: "d/l/x/evil", Typeflag: tar.TypeReg, Mode: 0o644, Size: 4},
+	})
+	if err := ExtractBundle(bundle, dst); err == nil {
+		t.Fatal("expected the bundle to be rejected")
+	}
+	for _, p := range []string{filepath.Join(root, "a", "evil"), filepath.Join(dst, "evil"), filepath.Join(root, "a", "x")} {
+		if _, err := os.Lstat(p); err == nil {
+			t.Errorf("%s was created", p)
+		}
+	}
+}
+
+func TestExtractBundleSymlinkThroughSymlink(t *testing.T) {
+	tests := []struct {
+		name string
+		hdrs []*tar.Header
+	}{
+		{
+			name: "through an extracted symlink",
+			hdrs: []*tar.Header{
+				{Name: "c/", Typeflag: tar.TypeDir, Mode: 0o755},
+				{Name: "a/", Typeflag: tar.TypeDir, Mode: 0o755},
+				{Name: "a/l", Typeflag: tar.TypeSymlink, Linkname: "../c"},
+				{Name: "s", Typeflag: tar.TypeSymlink, Linkname: "a/l/../.."},
+			},
+		},
+		{
+			name: "through a symlink extracted later",
+			hdrs: []*tar.Header{
+				{Name: "c/", Typeflag: tar.TypeDir, Mode: 0o755},
+				{Name: "a/", Typeflag: tar.TypeDir, Mode: 0o755},
+				{Name: "s", Typeflag: tar.TypeSymlink, Linkname: "a/l/../.."},
+				{Name: "a/l", Typeflag: tar.TypeSymlink, Linkname: "../c"},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "bundle")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+			dst := filepath.Join(root, "dst")
+
+			if err := ExtractBundle(tgz(t, tc.hdrs), dst); err == nil {
+				t.Fatal("expected the bundle to be rejected")
+			}
+			if _, err := os.Lstat(filepath.Join(dst, "s")); err == nil {
+				t.Error("the escaping symlink was created")
+			}
+		})
+	}
+}
+
+func TestExtractBundle(t *testing.T) {
+	dst, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	bundle := tgz(t, []*tar.Header{
+		{Name: "docker-compose.yml", Typeflag: tar.TypeReg, Mode: 0o644, Size: 3},
+		{Name: "conf/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "conf/app.conf", Typeflag: tar.TypeReg, Mode: 0o644, Size: 5},
+		{Name: "app.conf", Typeflag: tar.TypeSymlink, Linkname: "conf/app.conf"},
+		{Name: "conf/self", Typeflag: tar.TypeSymlink, Linkname: "../conf/./app.conf"},
+	})
+	if err := ExtractBundle(bundle, dst); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dst, "app.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 5 {
+		t.Errorf("unexpected app.conf size: %d", len(b))
+	}
+}
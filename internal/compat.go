@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CompatProfile defines what App manifests the devices of a fleet can handle
+type CompatProfile struct {
+	Name string
+	// MaxArchNumb is the maximum number of architectures an App can support, 0 if not limited
+	MaxArchNumb int
+	// MaxManifestBodySize is the maximum size of an App manifest
+	MaxManifestBodySize int
+	// PlatformOS is the value of the optional `os` field of the App layers
+	// manifests' platform, the field is omitted if empty
+	PlatformOS string
+	// RecordPlatforms enables the App platforms blob referenced by the App manifest
+	RecordPlatforms bool
+	// LayersIndex allows moving the App layers manifests list to an index
+	// blob if the App manifest is too big, see ReductionLayersIndex
+	LayersIndex bool
+}
+
+const DefaultCompatProfile = "aklite-legacy"
+
+var compatProfiles = map[string]CompatProfile{
+	// aklite rejects manifests bigger than 2048 bytes
+	"aklite-legacy": {
+		MaxArchNumb:         MaxArchNumb,
+		MaxManifestBodySize: MaxManifestBodySize,
+	},
+	// aklite rejects manifests bigger than 16K
+	"aklite-16k": {
+		MaxManifestBodySize: 16*1024 - 38,
+		// the OS aklite expects, as opposed to the OS of the App images
+		PlatformOS:      "LMP",
+		RecordPlatforms: true,
+		LayersIndex:     true,
+	},
+}
+
+// GetCompatProfile returns a compatibility profile by its name, or the
+// default profile if the name is empty
+func GetCompatProfile(name string) (CompatProfile, error) {
+	if len(name) == 0 {
+		name = DefaultCompatProfile
+	}
+	profile, ok := compatProfiles[name]
+	if !ok {
+		names := make([]string, 0, len(compatProfiles))
+		for n := range compatProfiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return CompatProfile{}, fmt.Errorf("unknown compatibility profile: %s, supported profiles: %s", name, strings.Join(names, ", "))
+	}
+	profile.Name = name
+	return profile, nil
+}
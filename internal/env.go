@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var envVarRef = regexp.MustCompile(`\$\{?([A-Za-z_][A-Za-z0-9_]*)`)
+
+// ReadEnvFile parses a file of `KEY=VALUE` lines, as used by `.env` and
+// `--env-file`. Empty lines and lines starting with `#` are skipped, an
+// optional `export ` prefix is allowed and values can be quoted.
+func ReadEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(key) == 0 {
+			return nil, fmt.Errorf("%s:%d: missing variable name", path, lineNo)
+		}
+		if len(parts) == 1 {
+			// a variable without value is taken from the process environment
+			if val, ok := os.LookupEnv(key); ok {
+				env[key] = val
+			}
+			continue
+		}
+		env[key] = parseEnvValue(strings.TrimSpace(parts[1]))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func parseEnvValue(val string) string {
+	if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') {
+		q := val[0]
+		for i := 1; i < len(val); i++ {
+			if q == '"' && val[i] == '\\' {
+				i++
+				continue
+			}
+			if val[i] != q {
+				continue
+			}
+			// a closing quote may only be followed by an inline comment
+			if rest := strings.TrimSpace(val[i+1:]); len(rest) > 0 && !strings.HasPrefix(rest, "#") {
+				break
+			}
+			unquoted := val[1:i]
+			if q == '"' {
+				unquoted = strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`).Replace(unquoted)
+			}
+			return unquoted
+		}
+	}
+	// strip an inline comment of an unquoted value
+	if i := strings.Index(val, " #"); i >= 0 {
+		val = strings.TrimSpace(val[:i])
+	}
+	return val
+}
+
+// LoadEnv returns the environment used to interpolate compose files. The
+// precedence from the lowest to the highest is: the project's `.env` file,
+// the env files in the given order, the process environment.
+func LoadEnv(projectDir string, envFiles []string) (map[string]string, []string, error) {
+	env := make(map[string]string)
+	var loaded []string
+
+	dotEnv := filepath.Join(projectDir, ".env")
+	if fi, err := os.Stat(dotEnv); err == nil && !fi.IsDir() {
+		envFiles = append([]string{dotEnv}, envFiles...)
+	}
+	for _, file := range envFiles {
+		fileEnv, err := ReadEnvFile(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read env file: %s", err)
+		}
+		for k, v := range fileEnv {
+			env[k] = v
+		}
+		loaded = append(loaded, file)
+	}
+
+	for _, val := range os.Environ() {
+		parts := strings.SplitN(val, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+	return env, loaded, nil
+}
+
+// ReferencedEnv returns the variables referenced by a compose value along
+// with their values in the given environment
+func ReferencedEnv(value string, env map[string]string) map[string]string {
+	refs := make(map[string]string)
+	for _, m := range envVarRef.FindAllStringSubmatch(value, -1) {
+		refs[m[1]] = env[m[1]]
+	}
+	return refs
+}
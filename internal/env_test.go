@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadEnvFile(t *testing.T) {
+	os.Setenv("COMPOSE_PUBLISH_TEST_FROM_ENV", "from-env")
+	defer os.Unsetenv("COMPOSE_PUBLISH_TEST_FROM_ENV")
+
+	tests := []struct {
+		name     string
+		content  string
+		expected map[string]string
+	}{
+		{
+			name:     "plain values",
+			content:  "A=1\nB = two\n",
+			expected: map[string]string{"A": "1", "B": "two"},
+		},
+		{
+			name:     "comments and empty lines are skipped",
+			content:  "# comment\n\n  # indented comment\nA=1\n",
+			expected: map[string]string{"A": "1"},
+		},
+		{
+			name:     "export prefix",
+			content:  "export A=1\n",
+			expected: map[string]string{"A": "1"},
+		},
+		{
+			name:     "equal sign inside a value",
+			content:  "URL=http://host/?a=b&c=d\nEMPTY=\n",
+			expected: map[string]string{"URL": "http://host/?a=b&c=d", "EMPTY": ""},
+		},
+		{
+			name:     "double quoted values",
+			content:  "A=\"a b\"\nB=\"line\\nnext\"\nC=\"say \\\"hi\\\"\"\nD=\"back\\\\slash\"\n",
+			expected: map[string]string{"A": "a b", "B": "line\nnext", "C": `say "hi"`, "D": `back\slash`},
+		},
+		{
+			name:     "single quoted values are taken literally",
+			content:  "A='a b'\nB='line\\n'\n",
+			expected: map[string]string{"A": "a b", "B": `line\n`},
+		},
+		{
+			name:     "inline comments",
+			content:  "A=1 # comment\nB=a#b\nC=\"x # y\" # comment\nD='z' #comment\n",
+			expected: map[string]string{"A": "1", "B": "a#b", "C": "x # y", "D": "z"},
+		},
+		{
+			name:     "unbalanced quotes are kept",
+			content:  "A=\"a\nB=\"a\" b\n",
+			expected: map[string]string{"A": `"a`, "B": `"a" b`},
+		},
+		{
+			name:     "variable without a value is taken from the environment",
+			content:  "COMPOSE_PUBLISH_TEST_FROM_ENV\nCOMPOSE_PUBLISH_TEST_UNSET\n",
+			expected: map[string]string{"COMPOSE_PUBLISH_TEST_FROM_ENV": "from-env"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".env")
+			if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			env, err := ReadEnvFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(env, tc.expected) {
+				t.Errorf("unexpected env\nexpected: %q\ngot:      %q", tc.expected, env)
+			}
+		})
+	}
+}
+
+func TestReadEnvFileMissingName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("A=1\n=2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadEnvFile(path); err == nil {
+		t.Error("expected a line without a variable name to fail")
+	}
+}
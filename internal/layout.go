@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// OCILayout is a directory in the OCI image layout format, an App can be
+// written to it instead of being published to a registry
+type OCILayout struct {
+	Dir string
+}
+
+// NewOCILayout creates an OCI image layout in the given directory, the
+// directory may exist but must not contain another layout
+func NewOCILayout(dir string) (*OCILayout, error) {
+	if _, err := os.Stat(filepath.Join(dir, v1.ImageLayoutFile)); err == nil {
+		return nil, fmt.Errorf("%s already contains an OCI image layout", dir)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", string(digest.Canonical)), 0o755); err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(v1.ImageLayout{Version: v1.ImageLayoutVersion})
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, v1.ImageLayoutFile), b, 0o644); err != nil {
+		return nil, err
+	}
+	return &OCILayout{Dir: dir}, nil
+}
+
+func (l *OCILayout) blobPath(d digest.Digest) string {
+	return filepath.Join(l.Dir, "blobs", d.Algorithm().String(), d.Encoded())
+}
+
+// WriteBlob stores a blob, or a manifest, in the layout and returns its descriptor
+func (l *OCILayout) WriteBlob(mediaType string, data []byte) (distribution.Descriptor, error) {
+	desc := distribution.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	if err := ioutil.WriteFile(l.blobPath(desc.Digest), data, 0o644); err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return desc, nil
+}
+
+// WriteIndex writes the layout's index.json referring to the App manifest
+// once per tag, the tag is recorded as the manifest's reference name. The
+// manifest is referred to without a reference name if there are no tags.
+func (l *OCILayout) WriteIndex(manifest distribution.Descriptor, tags []string) error {
+	index := v1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Manifests: []v1.Descriptor{},
+	}
+	desc := v1.Descriptor{MediaType: manifest.MediaType, Digest: manifest.Digest, Size: manifest.Size}
+	if len(tags) == 0 {
+		index.Manifests = append(index.Manifests, desc)
+	}
+	for _, tag := range tags {
+		desc.Annotations = map[string]string{v1.AnnotationRefName: tag}
+		index.Manifests = append(index.Manifests, desc)
+	}
+	b, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(l.Dir, "index.json"), b, 0o644)
+}
+
+// OpenOCILayout opens an existing OCI image layout
+func OpenOCILayout(dir string) (*OCILayout, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, v1.ImageLayoutFile))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not an OCI image layout: %s", dir, err)
+	}
+	var layout v1.ImageLayout
+	if err := json.Unmarshal(b, &layout); err != nil {
+		return nil, err
+	}
+	if layout.Version != v1.ImageLayoutVersion {
+		return nil, fmt.Errorf("unsupported OCI image layout version: %s", layout.Version)
+	}
+	return &OCILayout{Dir: dir}, nil
+}
+
+// ReadBlob reads a blob, or a manifest, from the layout and makes sure its
+// size and digest match the descriptor
+func (l *OCILayout) ReadBlob(desc distribution.Descriptor) ([]byte, error) {
+	b, err := l.readBlob(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) != desc.Size {
+		return nil, fmt.Errorf("blob %s size mismatch; expected: %d, got: %d", desc.Digest, desc.Size, len(b))
+	}
+	return b, nil
+}
+
+func (l *OCILayout) readBlob(dgst digest.Digest) ([]byte, error) {
+	if err := dgst.Validate(); err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(l.blobPath(dgst))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, distribution.ErrBlobUnknown
+		}
+		return nil, err
+	}
+	if d := dgst.Algorithm().FromBytes(b); d != dgst {
+		return nil, fmt.Errorf("blob digest mismatch; expected: %s, got: %s", dgst, d)
+	}
+	return b, nil
+}
+
+// ReadIndex reads the layout's index.json
+func (l *OCILayout) ReadIndex() (*v1.Index, error) {
+	b, err := ioutil.ReadFile(filepath.Join(l.Dir, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	var index v1.Index
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
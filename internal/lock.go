@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// LockFileName is the name of the lock file kept next to the App's compose file
+const LockFileName = "compose-app.lock"
+
+type (
+	// LockedImage is a service image as it was pinned
+	LockedImage struct {
+		// Image is the image reference as it is defined in the compose file
+		Image  string        `json:"image"`
+		Digest digest.Digest `json:"digest"`
+		// Manifests maps architectures to the image manifests for them
+		Manifests map[string]digest.Digest `json:"manifests,omitempty"`
+	}
+	// Lock records how the App images were pinned and what architectures
+	// were included, so a later publishing can reproduce exactly the same
+	// pins even if image tags have moved since
+	Lock struct {
+		Services      map[string]*LockedImage `json:"services"`
+		Architectures []string                `json:"architectures"`
+		// locked is set if the lock was read, i.e. the pinning is checked
+		// against it rather than recorded to it
+		locked bool
+	}
+)
+
+// NewLock returns an empty lock the App pinning is recorded to
+func NewLock() *Lock {
+	return &Lock{Services: make(map[string]*LockedImage)}
+}
+
+// ReadLock reads a lock file the App pinning has to reproduce
+func ReadLock(path string) (*Lock, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lock := NewLock()
+	if err := json.Unmarshal(b, lock); err != nil {
+		return nil, fmt.Errorf("failed to parse lock file %s: %s", path, err)
+	}
+	lock.locked = true
+	return lock, nil
+}
+
+func (l *Lock) WriteFile(path string) error {
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// pinned returns a service image digest recorded in the lock, if the lock
+// is used for pinning
+func (l *Lock) pinned(service, image string) (digest.Digest, bool, error) {
+	if l == nil || !l.locked {
+		return "", false, nil
+	}
+	locked, ok := l.Services[service]
+	if !ok {
+		return "", false, fmt.Errorf("Service(%s) is not in the lock file, update it with --write-lock", service)
+	}
+	if locked.Image != image {
+		return "", false, fmt.Errorf("Service(%s) image %s doesn't match the locked image %s, update the lock file with --write-lock",
+			service, image, locked.Image)
+	}
+	return locked.Digest, true, nil
+}
+
+func (l *Lock) pin(service, image string, dgst digest.Digest) {
+	if l == nil || l.locked {
+		return
+	}
+	locked, ok := l.Services[service]
+	if !ok {
+		locked = &LockedImage{Manifests: make(map[string]digest.Digest)}
+		l.Services[service] = locked
+	}
+	locked.Image, locked.Digest = image, dgst
+}
+
+// AddManifest records an image manifest for an architecture, or checks it
+// against the lock if the lock is used for pinning
+func (l *Lock) AddManifest(service, arch string, dgst digest.Digest) error {
+	if l == nil {
+		return nil
+	}
+	locked, ok := l.Services[service]
+	if !ok {
+		if l.locked {
+			return fmt.Errorf("Service(%s) is not in the lock file, update it with --write-lock", service)
+		}
+		locked = &LockedImage{Manifests: make(map[string]digest.Digest)}
+		l.Services[service] = locked
+	}
+	if !l.locked {
+		locked.Manifests[arch] = dgst
+		return nil
+	}
+	if d, ok := locked.Manifests[arch]; ok && d != dgst {
+		return fmt.Errorf("Service(%s) %s manifest %s doesn't match the locked manifest %s", service, arch, dgst, d)
+	}
+	return nil
+}
+
+// SetArchitectures records the App architectures, or checks them against
+// the lock if the lock is used for pinning
+func (l *Lock) SetArchitectures(archs []string) error {
+	if l == nil {
+		return nil
+	}
+	sorted := append([]string{}, archs...)
+	sort.Strings(sorted)
+	if l.locked {
+		if strings.Join(sorted, ",") != strings.Join(l.Architectures, ",") {
+			return fmt.Errorf("app architectures %q don't match the locked architectures %q", sorted, l.Architectures)
+		}
+		return nil
+	}
+	l.Architectures = sorted
+	included := make(map[string]bool, len(archs))
+	for _, arch := range archs {
+		included[arch] = true
+	}
+	// keep the manifests of the App architectures only
+	for _, svc := range l.Services {
+		for arch := range svc.Manifests {
+			if !included[arch] {
+				delete(svc.Manifests, arch)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Service attributes whose sequences are replaced by an override instead of being merged
+var replacedSequences = map[string]bool{
+	"command":    true,
+	"entrypoint": true,
+	"test":       true,
+}
+
+// Service attributes that can be defined either as a mapping or as a list of `key=value`
+var mappingOrList = map[string]bool{
+	"environment": true,
+	"labels":      true,
+	"args":        true,
+	"sysctls":     true,
+	"extra_hosts": true,
+}
+
+// MergeConfigs merges parsed compose files, each file overrides the ones
+// preceding it following the docker compose merge rules:
+//   - mappings are merged recursively and scalars are overridden,
+//   - `command`, `entrypoint` and healthcheck `test` are replaced,
+//   - `environment`, `labels` and similar are merged by key regardless of
+//     whether they are defined as a mapping or a list,
+//   - `volumes` are merged by mount target, `secrets` and `configs` by source,
+//   - other sequences are concatenated without duplicates.
+func MergeConfigs(configs []map[string]interface{}) (map[string]interface{}, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no compose configs to merge")
+	}
+	merged := configs[0]
+	for _, override := range configs[1:] {
+		m, err := mergeMaps(merged, override, "")
+		if err != nil {
+			return nil, err
+		}
+		merged = m
+	}
+	return merged, nil
+}
+
+func mergeMaps(base, override map[string]interface{}, path string) (map[string]interface{}, error) {
+	merged := make(map[string]interface{}, len(base))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		b, ok := merged[k]
+		if !ok || b == nil {
+			merged[k] = v
+			continue
+		}
+		m, err := mergeValues(b, v, k, path+"."+k)
+		if err != nil {
+			return nil, err
+		}
+		merged[k] = m
+	}
+	return merged, nil
+}
+
+func mergeValues(base, override interface{}, key, path string) (interface{}, error) {
+	if override == nil {
+		return base, nil
+	}
+	if mappingOrList[key] {
+		b, err := toMapping(base, path)
+		if err != nil {
+			return nil, err
+		}
+		o, err := toMapping(override, path)
+		if err != nil {
+			return nil, err
+		}
+		return mergeMaps(b, o, path)
+	}
+
+	switch o := override.(type) {
+	case map[string]interface{}:
+		b, ok := base.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unable to merge %s: a mapping can't override %T", path, base)
+		}
+		return mergeMaps(b, o, path)
+	case []interface{}:
+		b, ok := base.([]interface{})
+		if !ok || replacedSequences[key] {
+			return o, nil
+		}
+		switch key {
+		case "volumes":
+			return mergeSequenceByKey(b, o, volumeKey), nil
+		case "secrets", "configs":
+			return mergeSequenceByKey(b, o, sourceKey), nil
+		}
+		return mergeSequenceByKey(b, o, func(v interface{}) string { return fmt.Sprint(v) }), nil
+	default:
+		return override, nil
+	}
+}
+
+// toMapping converts a compose attribute defined as a list of `key=value`
+// (or `host:ip` for extra_hosts) to a mapping
+func toMapping(value interface{}, path string) (map[string]interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, nil
+	case []interface{}:
+		sep := "="
+		if strings.HasSuffix(path, ".extra_hosts") {
+			sep = ":"
+		}
+		m := make(map[string]interface{}, len(v))
+		for _, item := range v {
+			parts := strings.SplitN(fmt.Sprint(item), sep, 2)
+			if len(parts) == 2 {
+				m[parts[0]] = parts[1]
+			} else {
+				m[parts[0]] = nil
+			}
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unable to merge %s: unexpected type %T", path, value)
+	}
+}
+
+func mergeSequenceByKey(base, override []interface{}, key func(interface{}) string) []interface{} {
+	index := make(map[string]int, len(base))
+	merged := make([]interface{}, 0, len(base)+len(override))
+	for _, v := range append(append([]interface{}{}, base...), override...) {
+		k := key(v)
+		if i, ok := index[k]; ok {
+			merged[i] = v
+			continue
+		}
+		index[k] = len(merged)
+		merged = append(merged, v)
+	}
+	return merged
+}
+
+// volumeKey returns a mount target of a service volume in either the short
+// `[source:]target[:mode]` or the long syntax
+func volumeKey(v interface{}) string {
+	switch vol := v.(type) {
+	case map[string]interface{}:
+		return fmt.Sprint(vol["target"])
+	case string:
+		parts := strings.Split(vol, ":")
+		if len(parts) == 1 {
+			return parts[0]
+		}
+		return parts[1]
+	}
+	return fmt.Sprint(v)
+}
+
+// sourceKey returns a source of a service secret or config in either the
+// short or the long syntax
+func sourceKey(v interface{}) string {
+	if m, ok := v.(map[string]interface{}); ok {
+		return fmt.Sprint(m["source"])
+	}
+	return fmt.Sprint(v)
+}
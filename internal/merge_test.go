@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func parseYAML(t *testing.T, s string) map[string]interface{} {
+	var raw interface{}
+	if err := yaml.Unmarshal([]byte(s), &raw); err != nil {
+		t.Fatal(err)
+	}
+	return toStringKeys(raw).(map[string]interface{})
+}
+
+// toStringKeys converts yaml.v2 mappings to the form compose-go's loader returns
+func toStringKeys(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			m[k.(string)] = toStringKeys(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(val))
+		for i, item := range val {
+			l[i] = toStringKeys(item)
+		}
+		return l
+	}
+	return v
+}
+
+func TestMergeConfigs(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     string
+		override string
+		expected string
+	}{
+		{
+			name:     "scalars are overridden",
+			base:     "services: {app: {image: a, restart: always}}",
+			override: "services: {app: {image: b}}",
+			expected: "services: {app: {image: b, restart: always}}",
+		},
+		{
+			name:     "environment list is merged into a mapping",
+			base:     "services: {app: {environment: [A=1, B=2]}}",
+			override: "services: {app: {environment: {B: 3, C: 4}}}",
+			expected: "services: {app: {environment: {A: '1', B: 3, C: 4}}}",
+		},
+		{
+			name:     "environment mapping is merged with a list",
+			base:     "services: {app: {environment: {A: 1}}}",
+			override: "services: {app: {environment: [A=x=y, B]}}",
+			expected: "services: {app: {environment: {A: x=y, B: null}}}",
+		},
+		{
+			name:     "labels lists are merged by key",
+			base:     "services: {app: {labels: [a=1, b=2]}}",
+			override: "services: {app: {labels: [b=3]}}",
+			expected: "services: {app: {labels: {a: '1', b: '3'}}}",
+		},
+		{
+			name:     "extra_hosts are merged by host",
+			base:     "services: {app: {extra_hosts: ['h1:10.0.0.1', 'h2:10.0.0.2']}}",
+			override: "services: {app: {extra_hosts: ['h2:10.0.0.3']}}",
+			expected: "services: {app: {extra_hosts: {h1: 10.0.0.1, h2: 10.0.0.3}}}",
+		},
+		{
+			name:     "volumes are merged by target",
+			base:     "services: {app: {volumes: ['/a:/data', '/b:/conf:ro']}}",
+			override: "services: {app: {volumes: ['/c:/data', {type: bind, source: /d, target: /conf}]}}",
+			expected: "services: {app: {volumes: ['/c:/data', {type: bind, source: /d, target: /conf}]}}",
+		},
+		{
+			name:     "volumes with other targets are appended",
+			base:     "services: {app: {volumes: ['/a:/data']}}",
+			override: "services: {app: {volumes: ['/tmp']}}",
+			expected: "services: {app: {volumes: ['/a:/data', '/tmp']}}",
+		},
+		{
+			name:     "command is replaced",
+			base:     "services: {app: {command: [run, --a, --b]}}",
+			override: "services: {app: {command: [run, --c]}}",
+			expected: "services: {app: {command: [run, --c]}}",
+		},
+		{
+			name:     "healthcheck test is replaced",
+			base:     "services: {app: {healthcheck: {test: [CMD, a], interval: 1s}}}",
+			override: "services: {app: {healthcheck: {test: [CMD, b]}}}",
+			expected: "services: {app: {healthcheck: {test: [CMD, b], interval: 1s}}}",
+		},
+		{
+			name:     "other sequences are concatenated without duplicates",
+			base:     "services: {app: {ports: ['80:80', '443:443']}}",
+			override: "services: {app: {ports: ['443:443', '8080:8080']}}",
+			expected: "services: {app: {ports: ['80:80', '443:443', '8080:8080']}}",
+		},
+		{
+			name:     "services are added",
+			base:     "services: {a: {image: a}}",
+			override: "services: {b: {image: b}}",
+			expected: "services: {a: {image: a}, b: {image: b}}",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			merged, err := MergeConfigs([]map[string]interface{}{parseYAML(t, tc.base), parseYAML(t, tc.override)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := parseYAML(t, tc.expected); !reflect.DeepEqual(merged, expected) {
+				t.Errorf("unexpected merge result\nexpected: %v\ngot:      %v", expected, merged)
+			}
+		})
+	}
+}
+
+func TestMergeConfigsTypeMismatch(t *testing.T) {
+	base := parseYAML(t, "services: {app: {build: {context: .}}}")
+	override := parseYAML(t, "services: {app: {build: [a]}}")
+	if _, err := MergeConfigs([]map[string]interface{}{override, base}); err == nil {
+		t.Error("expected a mapping overriding a sequence to fail")
+	}
+}
@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"strings"
+)
+
+// normalizeArch returns the canonical architecture and variant, as they are
+// set by the docker and OCI tooling, of an architecture and variant
+func normalizeArch(arch, variant string) (string, string) {
+	arch, variant = strings.ToLower(arch), strings.ToLower(variant)
+	switch arch {
+	case "i386":
+		arch = "386"
+	case "x86_64", "x86-64":
+		arch = "amd64"
+	case "aarch64", "arm64":
+		arch = "arm64"
+		// v8 is the default arm64 variant
+		if variant == "8" || variant == "v8" {
+			variant = ""
+		}
+	case "armhf":
+		arch, variant = "arm", "v7"
+	case "armel":
+		arch, variant = "arm", "v6"
+	case "arm":
+		switch variant {
+		// v7 is the default arm variant
+		case "", "7":
+			variant = "v7"
+		case "5", "6", "8":
+			variant = "v" + variant
+		}
+	}
+	return arch, variant
+}
+
+// PlatformKey returns a normalized `arch[/variant]` key of a platform, the
+// App layers are resolved per platform key, e.g. `arm64` and `arm64/v8`
+// are the same platform, while `arm/v6` and `arm/v7` are different ones
+func PlatformKey(arch, variant string) string {
+	arch, variant = normalizeArch(arch, variant)
+	if len(variant) == 0 {
+		return arch
+	}
+	return arch + "/" + variant
+}
+
+// ParsePlatformKey returns the architecture and variant of a platform key
+func ParsePlatformKey(key string) (string, string) {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// PlatformMatches tells whether a platform key matches an entry of the
+// factory architecture list, `supported` are the platform keys all the App
+// images are available for. An entry with a variant matches that variant
+// only, e.g. `arm64/v8` matches `arm64` and `armhf` matches `arm/v7`. An
+// entry without a variant, e.g. `arm`, matches any variant of the
+// architecture unless the default variant is supported too, then it matches
+// the default variant only.
+func PlatformMatches(entry, key string, supported []string) bool {
+	entryArch, entryVariant := ParsePlatformKey(entry)
+	defaultKey := PlatformKey(entryArch, entryVariant)
+	if key == defaultKey {
+		return true
+	}
+	arch, _ := ParsePlatformKey(defaultKey)
+	keyArch, _ := ParsePlatformKey(key)
+	// `armhf` and alike imply a variant, so they match it only
+	if len(entryVariant) > 0 || strings.ToLower(entryArch) != arch || keyArch != arch {
+		return false
+	}
+	for _, s := range supported {
+		if s == defaultKey {
+			return false
+		}
+	}
+	return true
+}
+
+// PublishedPlatformKeys maps the platform keys of an App to the keys its
+// layers manifests are published with. The variant is omitted, so the App
+// is published the same way as before the variants were taken into account,
+// unless more than one variant of the architecture is supported by the App.
+func PublishedPlatformKeys(keys []string) map[string]string {
+	variants := make(map[string]int)
+	for _, key := range keys {
+		arch, _ := ParsePlatformKey(key)
+		variants[arch]++
+	}
+	published := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if arch, _ := ParsePlatformKey(key); variants[arch] == 1 {
+			published[key] = arch
+		} else {
+			published[key] = key
+		}
+	}
+	return published
+}
+
+// LayersManifestKey returns the platform key of a published App layers
+// manifest, the platform is taken as is since it's set from one of the keys
+// returned by PublishedPlatformKeys
+func LayersManifestKey(arch, variant string) string {
+	if len(variant) == 0 {
+		return arch
+	}
+	return arch + "/" + variant
+}
+
+// DefaultOS is the OS the App layers are resolved for if not specified
+const DefaultOS = "linux"
+
+// OSMatches tells whether a platform OS is the target OS, a platform that
+// doesn't specify its OS is assumed to be of the target OS
+func OSMatches(os, target string) bool {
+	return len(os) == 0 || strings.EqualFold(os, target)
+}
+
+// ReferenceTypeAnnotation is set by buildkit on image index entries of attestation manifests
+const ReferenceTypeAnnotation = "vnd.docker.reference.type"
+
+// IsAttestation tells whether an image index entry refers to an attestation
+// manifest, e.g. a provenance or SBOM one, rather than an image of a platform
+func IsAttestation(arch, os string, annotations map[string]string) bool {
+	if _, ok := annotations[ReferenceTypeAnnotation]; ok {
+		return true
+	}
+	return arch == "unknown" && os == "unknown"
+}
+
+// PlatformName returns a normalized `os/arch[/variant]` name of a platform
+func PlatformName(os, arch, variant string) string {
+	return strings.ToLower(os) + "/" + PlatformKey(arch, variant)
+}
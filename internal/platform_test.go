@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlatformKey(t *testing.T) {
+	tests := []struct {
+		arch     string
+		variant  string
+		expected string
+	}{
+		{"amd64", "", "amd64"},
+		{"x86_64", "", "amd64"},
+		{"i386", "", "386"},
+		{"arm64", "", "arm64"},
+		{"arm64", "v8", "arm64"},
+		{"arm64", "8", "arm64"},
+		{"aarch64", "", "arm64"},
+		{"ARM64", "V8", "arm64"},
+		{"arm", "", "arm/v7"},
+		{"arm", "7", "arm/v7"},
+		{"arm", "v7", "arm/v7"},
+		{"arm", "v6", "arm/v6"},
+		{"arm", "6", "arm/v6"},
+		{"armhf", "", "arm/v7"},
+		{"armel", "", "arm/v6"},
+	}
+	for _, tc := range tests {
+		if key := PlatformKey(tc.arch, tc.variant); key != tc.expected {
+			t.Errorf("PlatformKey(%q, %q); expected: %s, got: %s", tc.arch, tc.variant, tc.expected, key)
+		}
+	}
+}
+
+func TestPlatformMatches(t *testing.T) {
+	tests := []struct {
+		entry     string
+		key       string
+		supported []string
+		expected  bool
+	}{
+		{"arm", "arm/v7", []string{"arm/v7"}, true},
+		{"arm", "arm/v6", []string{"arm/v6"}, true},
+		{"arm", "arm/v6", []string{"arm/v6", "arm64"}, true},
+		{"arm", "arm/v7", []string{"arm/v6", "arm/v7"}, true},
+		{"arm", "arm/v6", []string{"arm/v6", "arm/v7"}, false},
+		{"arm", "arm/v5", []string{"arm/v5", "arm/v6"}, true},
+		{"arm", "arm64", []string{"arm64"}, false},
+		{"arm/v6", "arm/v6", []string{"arm/v6", "arm/v7"}, true},
+		{"arm/v6", "arm/v7", []string{"arm/v6", "arm/v7"}, false},
+		{"arm/v7", "arm/v6", []string{"arm/v6"}, false},
+		{"armhf", "arm/v7", []string{"arm/v7"}, true},
+		{"armhf", "arm/v6", []string{"arm/v6"}, false},
+		{"arm64", "arm64", []string{"arm64"}, true},
+		{"arm64/v8", "arm64", []string{"arm64"}, true},
+		{"aarch64", "arm64", []string{"arm64"}, true},
+		{"amd64", "arm64", []string{"amd64", "arm64"}, false},
+	}
+	for _, tc := range tests {
+		if matches := PlatformMatches(tc.entry, tc.key, tc.supported); matches != tc.expected {
+			t.Errorf("PlatformMatches(%q, %q, %q); expected: %v, got: %v", tc.entry, tc.key, tc.supported, tc.expected, matches)
+		}
+	}
+}
+
+func TestPublishedPlatformKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     []string
+		expected map[string]string
+	}{
+		{
+			name:     "no variants",
+			keys:     []string{"amd64", "arm64"},
+			expected: map[string]string{"amd64": "amd64", "arm64": "arm64"},
+		},
+		{
+			name:     "one arm variant",
+			keys:     []string{"amd64", "arm/v7"},
+			expected: map[string]string{"amd64": "amd64", "arm/v7": "arm"},
+		},
+		{
+			name:     "several arm variants",
+			keys:     []string{"arm/v6", "arm/v7", "arm64"},
+			expected: map[string]string{"arm/v6": "arm/v6", "arm/v7": "arm/v7", "arm64": "arm64"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if published := PublishedPlatformKeys(tc.keys); !reflect.DeepEqual(published, tc.expected) {
+				t.Errorf("expected: %v, got: %v", tc.expected, published)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ApplyProfiles removes services that are not enabled by any of the active
+// profiles from the compose services, services without profiles are always
+// enabled and the `*` profile enables all services. The `profiles` attribute
+// is dropped from the remaining services, so they are started without having
+// to activate the profiles on a device.
+// It returns names of the removed services mapped to their profiles, and
+// fails if any of the remaining services depends on a removed one.
+func ApplyProfiles(services map[string]interface{}, profiles []string) (map[string][]string, error) {
+	active := make(map[string]bool, len(profiles))
+	for _, p := range profiles {
+		active[p] = true
+	}
+	all := active["*"]
+
+	removed := make(map[string][]string)
+	for name, obj := range services {
+		svc, ok := obj.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		value, ok := svc["profiles"]
+		if !ok {
+			continue
+		}
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Service(%s) has invalid 'profiles' format", name)
+		}
+		var svcProfiles []string
+		enabled := all || len(list) == 0
+		for _, p := range list {
+			profile := fmt.Sprint(p)
+			svcProfiles = append(svcProfiles, profile)
+			if active[profile] {
+				enabled = true
+			}
+		}
+		if enabled {
+			delete(svc, "profiles")
+		} else {
+			sort.Strings(svcProfiles)
+			removed[name] = svcProfiles
+			delete(services, name)
+		}
+	}
+
+	// a published service must not refer to a removed one, it would fail on a device
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		svc, ok := services[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, dep := range serviceDependencies(svc) {
+			if profiles, ok := removed[dep]; ok {
+				return nil, fmt.Errorf("Service(%s) depends on service %s of inactive profiles %q, activate one of them", name, dep, profiles)
+			}
+		}
+	}
+	return removed, nil
+}
+
+// serviceDependencies returns names of the services a service refers to by
+// `depends_on`, `links`, `volumes_from` and `service:` network, ipc and pid modes
+func serviceDependencies(svc map[string]interface{}) []string {
+	var deps []string
+	switch v := svc["depends_on"].(type) {
+	case []interface{}:
+		for _, d := range v {
+			deps = append(deps, fmt.Sprint(d))
+		}
+	case map[string]interface{}:
+		for d := range v {
+			deps = append(deps, d)
+		}
+	}
+	if links, ok := svc["links"].([]interface{}); ok {
+		for _, l := range links {
+			deps = append(deps, strings.SplitN(fmt.Sprint(l), ":", 2)[0])
+		}
+	}
+	if volumes, ok := svc["volumes_from"].([]interface{}); ok {
+		for _, v := range volumes {
+			if from := fmt.Sprint(v); !strings.HasPrefix(from, "container:") {
+				deps = append(deps, strings.SplitN(from, ":", 2)[0])
+			}
+		}
+	}
+	for _, mode := range []string{"network_mode", "ipc", "pid"} {
+		if v, ok := svc[mode].(string); ok && strings.HasPrefix(v, "service:") {
+			deps = append(deps, strings.TrimPrefix(v, "service:"))
+		}
+	}
+	sort.Strings(deps)
+	return deps
+}
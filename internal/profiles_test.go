@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestApplyProfiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles []string
+		kept     []string
+		removed  map[string][]string
+	}{
+		{
+			name:    "no active profiles",
+			kept:    []string{"app"},
+			removed: map[string][]string{"debug": {"debug"}, "all": {"*"}},
+		},
+		{
+			name:     "one active profile",
+			profiles: []string{"debug"},
+			kept:     []string{"app", "debug"},
+			removed:  map[string][]string{"all": {"*"}},
+		},
+		{
+			name:     "all profiles are active",
+			profiles: []string{"*"},
+			kept:     []string{"all", "app", "debug"},
+			removed:  map[string][]string{},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			services := map[string]interface{}{
+				"app":   map[string]interface{}{"image": "app"},
+				"debug": map[string]interface{}{"image": "debug", "profiles": []interface{}{"debug"}},
+				"all":   map[string]interface{}{"image": "all", "profiles": []interface{}{"*"}},
+			}
+			removed, err := ApplyProfiles(services, tc.profiles)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(removed, tc.removed) {
+				t.Errorf("unexpected removed services; expected: %v, got: %v", tc.removed, removed)
+			}
+			var kept []string
+			for name, svc := range services {
+				if _, ok := svc.(map[string]interface{})["profiles"]; ok {
+					t.Errorf("profiles are not dropped from service %s", name)
+				}
+				kept = append(kept, name)
+			}
+			sort.Strings(kept)
+			if !reflect.DeepEqual(kept, tc.kept) {
+				t.Errorf("unexpected kept services; expected: %v, got: %v", tc.kept, kept)
+			}
+		})
+	}
+}
+
+func TestApplyProfilesDependencies(t *testing.T) {
+	tests := []struct {
+		name  string
+		app   map[string]interface{}
+		fails bool
+	}{
+		{"depends_on list", map[string]interface{}{"depends_on": []interface{}{"debug"}}, true},
+		{"depends_on mapping", map[string]interface{}{"depends_on": map[string]interface{}{"debug": map[string]interface{}{"condition": "service_started"}}}, true},
+		{"links", map[string]interface{}{"links": []interface{}{"debug:dbg"}}, true},
+		{"volumes_from", map[string]interface{}{"volumes_from": []interface{}{"debug:ro"}}, true},
+		{"volumes_from a container", map[string]interface{}{"volumes_from": []interface{}{"container:debug"}}, false},
+		{"network_mode", map[string]interface{}{"network_mode": "service:debug"}, true},
+		{"enabled dependency", map[string]interface{}{"depends_on": []interface{}{"db"}}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.app["image"] = "app"
+			services := map[string]interface{}{
+				"app":   tc.app,
+				"db":    map[string]interface{}{"image": "db"},
+				"debug": map[string]interface{}{"image": "debug", "profiles": []interface{}{"debug"}},
+			}
+			if _, err := ApplyProfiles(services, nil); tc.fails != (err != nil) {
+				t.Errorf("unexpected result: %v", err)
+			}
+		})
+	}
+}
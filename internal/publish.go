@@ -6,13 +6,13 @@ import (
 	"compress/gzip"
 	"context"
 	"crypto/sha256"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -27,21 +27,25 @@ import (
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/foundriesio/compose-publish/pkg/events"
 )
 
 const (
-	// limits derived from aklite's limitation on an App manifest size
+	// limits of the aklite-legacy compatibility profile, derived from the
+	// legacy aklite limitation on an App manifest size
 	MaxArchNumb         = 6
 	MaxManifestBodySize = 2010 // (2048 - 38) just in case
 )
 
-func iterateServices(services map[string]interface{}, proj *compose.Project, fn compose.ServiceFunc) error {
+func iterateServices(services map[string]interface{}, proj *compose.Project, sink events.Sink, fn compose.ServiceFunc) error {
 	return proj.WithServices(nil, func(s compose.ServiceConfig) error {
 		obj := services[s.Name]
 		_, ok := obj.(map[string]interface{})
 		if !ok {
 			if s.Name == "extensions" {
-				fmt.Println("Hacking around https://github.com/compose-spec/compose-go/issues/91")
+				sink.Handle(events.Warning{Message: "Hacking around https://github.com/compose-spec/compose-go/issues/91"})
 				return nil
 			}
 			return fmt.Errorf("Service(%s) has invalid format", s.Name)
@@ -50,10 +54,46 @@ func iterateServices(services map[string]interface{}, proj *compose.Project, fn
 	})
 }
 
-func PinServiceImages(cli *client.Client, ctx context.Context, services map[string]interface{}, proj *compose.Project, pinnedImages map[string]digest.Digest) error {
+// resolveImage returns the digest of an image, it's resolved from the image
+// source if there is one, or from the registry repo otherwise
+func resolveImage(ctx context.Context, repo distribution.Repository, named reference.Named, sources ImageSources, pinnedImages map[string]digest.Digest) (digest.Digest, error) {
+	if src, ok := sources[named.Name()]; ok {
+		desc, err := src.Resolve(named)
+		if err != nil {
+			return "", err
+		}
+		return desc.Digest, nil
+	}
+	switch v := named.(type) {
+	case reference.Tagged:
+		desc, err := repo.Tags(ctx).Get(ctx, v.Tag())
+		if err != nil {
+			return "", err
+		}
+		return desc.Digest, nil
+	case reference.Digested:
+		return v.Digest(), nil
+	default:
+		if dgst, ok := pinnedImages[named.Name()]; ok {
+			return dgst, nil
+		}
+		return "", fmt.Errorf("Invalid reference type for %s: %T. Images must be pinned to a `:<tag>` or `@sha256:<hash>`", named, named)
+	}
+}
+
+// PinServiceImages pins service images to digests. Images that have a local
+// source, either specified in the compose file or among the given image
+// sources, are resolved from it rather than from a registry, such images
+// are added to the image sources so their layers are resolved from it too.
+// If the lock is read from a lock file, images are pinned to the locked
+// digests, otherwise the pinning is recorded to the lock if it's not nil.
+func PinServiceImages(cli *client.Client, ctx context.Context, services map[string]interface{}, proj *compose.Project, pinnedImages map[string]digest.Digest, sources ImageSources, lock *Lock, targetOS string, sink events.Sink) error {
 	regc := NewRegistryClient()
+	if sources == nil {
+		sources = make(ImageSources)
+	}
 
-	return iterateServices(services, proj, func(s compose.ServiceConfig) error {
+	return iterateServices(services, proj, sink, func(s compose.ServiceConfig) error {
 		name := s.Name
 		obj := services[name]
 		svc := obj.(map[string]interface{})
@@ -63,37 +103,38 @@ func PinServiceImages(cli *client.Client, ctx context.Context, services map[stri
 			return fmt.Errorf("Service(%s) missing 'image' attribute", name)
 		}
 		if s.Build != nil {
-			fmt.Printf("Removing service(%s) 'build' stanza\n", name)
+			sink.Handle(events.BuildRemoved{Service: name})
 			delete(svc, "build")
 		}
 
-		fmt.Printf("Pinning %s(%s)\n", name, image)
-		named, err := reference.ParseNormalizedNamed(image)
-		if err != nil {
+		var named reference.Named
+		var err error
+		if IsImageSource(image) {
+			src, err := ParseImageSource(image)
+			if err != nil {
+				return err
+			}
+			if named, err = src.ImageName(); err != nil {
+				return err
+			}
+			sources[named.Name()] = src
+		} else if named, err = reference.ParseNormalizedNamed(image); err != nil {
 			return err
 		}
 
-		repo, err := regc.GetRepository(ctx, named)
+		repo, err := sources.GetRepository(ctx, &regc, named)
 		if err != nil {
 			return err
 		}
 
-		var digest digest.Digest
-		switch v := named.(type) {
-		case reference.Tagged:
-			tag := v.Tag()
-			desc, err := repo.Tags(ctx).Get(ctx, tag)
-			if err != nil {
+		digest, locked, err := lock.pinned(name, image)
+		if err != nil {
+			return err
+		}
+		if !locked {
+			if digest, err = resolveImage(ctx, repo, named, sources, pinnedImages); err != nil {
 				return fmt.Errorf("Unable to find image reference(%s): %s", image, err)
 			}
-			digest = desc.Digest
-		case reference.Digested:
-			digest = v.Digest()
-		default:
-			var ok bool
-			if digest, ok = pinnedImages[named.Name()]; !ok {
-				return fmt.Errorf("Invalid reference type for %s: %T. Images must be pinned to a `:<tag>` or `@sha256:<hash>`", named, named)
-			}
 		}
 
 		mansvc, err := repo.Manifests(ctx, nil)
@@ -105,36 +146,38 @@ func PinServiceImages(cli *client.Client, ctx context.Context, services map[stri
 			return fmt.Errorf("Unable to find image manifest(%s): %s", image, err)
 		}
 
-		// TODO - we should find the intersection of platforms so
-		// that we can denote the platforms this app can run on
+		// the platforms the App can run on, i.e. the intersection of the image
+		// platforms, are found when the App layers are resolved
 		pinned := reference.Domain(named) + "/" + reference.Path(named) + "@" + digest.String()
 
+		var platforms []string
 		switch mani := man.(type) {
 		case *manifestlist.DeserializedManifestList:
-			fmt.Printf("  | ")
-			for i, m := range mani.Manifests {
-				if i != 0 {
-					fmt.Printf(", ")
+			// a docker manifest list or an OCI image index
+			for _, m := range mani.Manifests {
+				if IsAttestation(m.Platform.Architecture, m.Platform.OS, m.Annotations) {
+					continue
 				}
-				fmt.Printf(m.Platform.Architecture)
-				if m.Platform.Architecture == "arm" {
-					fmt.Printf(m.Platform.Variant)
+				if len(m.Platform.Architecture) == 0 || !OSMatches(m.Platform.OS, targetOS) {
+					continue
 				}
+				platforms = append(platforms, PlatformKey(m.Platform.Architecture, m.Platform.Variant))
 			}
-		case *schema2.DeserializedManifest:
+		case *schema2.DeserializedManifest, *ocischema.DeserializedManifest:
 			break
 		default:
-			return fmt.Errorf("Unexpected manifest: %v", mani)
+			return fmt.Errorf("Unexpected manifest: %T", mani)
 		}
 
-		fmt.Println("\n  |-> ", pinned)
 		svc["image"] = pinned
+		lock.pin(name, image, digest)
+		sink.Handle(events.ImagePinned{Service: name, Image: image, Pinned: pinned, Platforms: platforms})
 		return nil
 	})
 }
 
-func PinServiceConfigs(cli *client.Client, ctx context.Context, services map[string]interface{}, proj *compose.Project) error {
-	return iterateServices(services, proj, func(s compose.ServiceConfig) error {
+func PinServiceConfigs(cli *client.Client, ctx context.Context, services map[string]interface{}, proj *compose.Project, sink events.Sink) error {
+	return iterateServices(services, proj, sink, func(s compose.ServiceConfig) error {
 		obj := services[s.Name]
 		svc := obj.(map[string]interface{})
 
@@ -144,12 +187,12 @@ func PinServiceConfigs(cli *client.Client, ctx context.Context, services map[str
 		}
 
 		srvh := sha256.Sum256(marshalled)
-		fmt.Printf("   |-> %s : %x\n", s.Name, srvh)
 		if s.Labels == nil {
 			s.Labels = make(map[string]string)
 		}
 		s.Labels["io.compose-spec.config-hash"] = fmt.Sprintf("%x", srvh)
 		svc["labels"] = s.Labels
+		sink.Handle(events.ConfigHashed{Service: s.Name, Hash: s.Labels["io.compose-spec.config-hash"]})
 		return nil
 	})
 }
@@ -167,10 +210,10 @@ func getIgnores(appDir string) []string {
 	return ignores
 }
 
-func createTgz(composeContent []byte, appDir string) ([]byte, error) {
+func createTgz(composeContent []byte, appDir string, excludes []string) ([]byte, error) {
 	reader, err := archive.TarWithOptions(appDir, &archive.TarOptions{
 		Compression:     archive.Uncompressed,
-		ExcludePatterns: getIgnores(appDir),
+		ExcludePatterns: append(getIgnores(appDir), excludes...),
 	})
 	if err != nil {
 		return nil, err
@@ -223,30 +266,181 @@ func createTgz(composeContent []byte, appDir string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func CreateApp(ctx context.Context, config map[string]interface{}, target string, dryRun bool, layerManifests []distribution.Descriptor, appLayersMetaData []byte) (string, error) {
-	pinned, err := yaml.Marshal(config)
+// DryRunBundleFile is where the App bundle is written to for a dry run, so
+// that it can be inspected
+const DryRunBundleFile = "/tmp/compose-bundle.tgz"
+
+func CreateApp(ctx context.Context, config map[string]interface{}, appDir, target string, dryRun bool, layerManifests []distribution.Descriptor, appLayersMetaData []byte, sink events.Sink) (string, error) {
+	pinned, buff, err := CreateBundle(config, appDir, nil)
+	if err != nil {
+		return "", err
+	}
+	tags, err := AppTags(target, nil, false)
+	if err != nil {
+		return "", err
+	}
+	profile, err := GetCompatProfile(DefaultCompatProfile)
 	if err != nil {
 		return "", err
 	}
+	dgst, err := PushApp(ctx, pinned, buff, target, tags, dryRun, layerManifests, appLayersMetaData, nil, profile, false, sink)
+	if err == nil && dryRun {
+		err = ioutil.WriteFile(DryRunBundleFile, buff, 0755)
+	}
+	return dgst, err
+}
 
-	pinnedHash := sha256.Sum256(pinned)
-	fmt.Printf("  |-> pinned content hash: %x\n", pinnedHash)
+// appBlob is a blob referred to by an App manifest
+type appBlob struct {
+	// kind is the blob kind reported by events, empty for the manifest config
+	kind string
+	desc distribution.Descriptor
+	data []byte
+}
 
-	buff, err := createTgz(pinned, "./")
+// AppTags returns the tags an App is published with: the target's tag
+// followed by the extra tags, or `latest` if there are none of them. No
+// tags are returned if the App is published by digest only.
+func AppTags(target string, extraTags []string, digestOnly bool) ([]string, error) {
+	named, err := reference.ParseNormalizedNamed(target)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	var tags []string
+	if tagged, ok := named.(reference.Tagged); ok {
+		tags = append(tags, tagged.Tag())
+	}
+	for _, tag := range extraTags {
+		if _, err := reference.WithTag(named, tag); err != nil {
+			return nil, fmt.Errorf("invalid tag %s: %s", tag, err)
+		}
+		found := false
+		for _, t := range tags {
+			found = found || t == tag
+		}
+		if !found {
+			tags = append(tags, tag)
+		}
+	}
+	if digestOnly {
+		if len(tags) > 0 {
+			return nil, fmt.Errorf("an App published by digest only can't be tagged with %q", tags)
+		}
+		return nil, nil
+	}
+	if len(tags) == 0 {
+		tags = append(tags, "latest")
+	}
+	return tags, nil
+}
+
+// composeApp builds the App manifest referring to the App bundle, the
+// layers metadata, the App platforms and the App layers manifests, and
+// checks its size. If the manifest is too big and `optimize` is set, the size
+// reductions allowed by the compatibility profile are applied one by one
+// until it fits. It returns the manifest along with the blobs it refers to.
+func composeApp(pinned, buff []byte, layerManifests []distribution.Descriptor, appLayersMetaData, platformsData []byte, profile CompatProfile, optimize bool, sink events.Sink) (*ocischema.DeserializedManifest, []appBlob, error) {
+	sink.Handle(events.BundleCreated{
+		ComposeDigest: digest.FromBytes(pinned),
+		Digest:        digest.FromBytes(buff),
+		Size:          int64(len(buff)),
+	})
+
+	config := []byte{}
+	blobs := []appBlob{
+		{desc: distribution.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: digest.FromBytes(config)}, data: config},
+		{kind: events.BlobBundle, desc: distribution.Descriptor{MediaType: BundleMediaType, Digest: digest.FromBytes(buff), Size: int64(len(buff))}, data: buff},
+	}
+	if appLayersMetaData != nil {
+		blobs = append(blobs, appBlob{
+			kind: events.BlobLayersMeta,
+			desc: distribution.Descriptor{
+				MediaType:   "application/json",
+				Digest:      digest.FromBytes(appLayersMetaData),
+				Size:        int64(len(appLayersMetaData)),
+				Annotations: map[string]string{"layers-meta": "v1"},
+			},
+			data: appLayersMetaData,
+		})
+	}
+	if platformsData != nil {
+		blobs = append(blobs, appBlob{
+			kind: events.BlobPlatforms,
+			desc: distribution.Descriptor{
+				MediaType:   "application/json",
+				Digest:      digest.FromBytes(platformsData),
+				Size:        int64(len(platformsData)),
+				Annotations: map[string]string{"platforms": "v1"},
+			},
+			data: platformsData,
+		})
+	}
+
+	app := &appManifestDef{blobs: blobs, manifests: layerManifests}
+	man, b1, err := app.build()
+	if err != nil {
+		return nil, nil, err
+	}
+	var applied []string
+	if optimize {
+		for _, r := range sizeReductions {
+			if len(b1) < profile.MaxManifestBodySize {
+				break
+			}
+			if !r.allowed(profile) {
+				continue
+			}
+			if err := r.apply(app); err != nil {
+				return nil, nil, err
+			}
+			if man, b1, err = app.build(); err != nil {
+				return nil, nil, err
+			}
+			applied = append(applied, r.name)
+		}
 	}
 
-	archHash := sha256.Sum256(buff)
-	fmt.Printf("  |-> app archive hash: %x\n", archHash)
+	sink.Handle(events.ManifestBuilt{
+		Digest:        digest.FromBytes(b1),
+		Size:          len(b1),
+		SizeLimit:     profile.MaxManifestBodySize,
+		CompatProfile: profile.Name,
+		Reductions:    applied,
+	})
+	// the limit is imposed by aklite running on devices, see CompatProfile
+	if len(b1) >= profile.MaxManifestBodySize {
+		msg := fmt.Sprintf("app manifest size (%d) exceeds the maximum size limit (%d) of the %s compatibility profile",
+			len(b1), profile.MaxManifestBodySize, profile.Name)
+		if len(applied) > 0 {
+			msg += fmt.Sprintf(" even with the size reductions applied (%s)", strings.Join(applied, ", "))
+		}
+		breakdown, err := manifestSizeBreakdown(b1)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("%s; %s", msg, breakdown)
+	}
+	return man, app.blobs, nil
+}
 
+// PushApp publishes an App bundle along with the App manifest referring to it,
+// `pinned` is the pinned compose file content included into the bundle.
+// The manifest is tagged with all the given tags, or pushed by digest only
+// if there are no tags.
+func PushApp(ctx context.Context, pinned, buff []byte, target string, tags []string, dryRun bool, layerManifests []distribution.Descriptor, appLayersMetaData, platformsData []byte, profile CompatProfile, optimize bool, sink events.Sink) (string, error) {
 	named, err := reference.ParseNormalizedNamed(target)
 	if err != nil {
 		return "", err
 	}
-	tag := "latest"
-	if tagged, ok := reference.TagNameOnly(named).(reference.Tagged); ok {
-		tag = tagged.Tag()
+
+	man, blobs, err := composeApp(pinned, buff, layerManifests, appLayersMetaData, platformsData, profile, optimize, sink)
+	if err != nil {
+		return "", err
+	}
+
+	if dryRun {
+		sink.Handle(events.PublishSkipped{Compose: pinned})
+		return "", nil
 	}
 
 	regc := NewRegistryClient()
@@ -254,93 +448,219 @@ func CreateApp(ctx context.Context, config map[string]interface{}, target string
 	if err != nil {
 		return "", err
 	}
+	return pushApp(ctx, repo, man, blobs, tags, sink)
+}
 
-	if dryRun {
-		fmt.Println("Pinned compose:")
-		fmt.Println(string(pinned))
-		fmt.Println("Skipping publishing for dryrun")
-
-		if err := ioutil.WriteFile("/tmp/compose-bundle.tgz", buff, 0755); err != nil {
+// pushApp uploads the App blobs and the App manifest, the blobs that are
+// already in the repository are not uploaded again, and the manifest is not
+// pushed again if it's already tagged with all the tags
+func pushApp(ctx context.Context, repo distribution.Repository, man *ocischema.DeserializedManifest, blobs []appBlob, tags []string, sink events.Sink) (string, error) {
+	blobStore := repo.Blobs(ctx)
+	for _, b := range blobs {
+		if desc, err := blobStore.Stat(ctx, b.desc.Digest); err == nil && desc.Size == b.desc.Size {
+			if len(b.kind) > 0 {
+				sink.Handle(events.BlobUploaded{Kind: b.kind, Descriptor: b.desc, Unchanged: true})
+			}
+			continue
+		} else if err != nil && err != distribution.ErrBlobUnknown {
 			return "", err
 		}
-
-		return "", nil
+		desc, err := blobStore.Put(ctx, b.desc.MediaType, b.data)
+		if err != nil {
+			return "", fmt.Errorf("failed to put %s blob to the App blob store: %s", b.desc.MediaType, err.Error())
+		}
+		if desc.Digest != b.desc.Digest || desc.Size != b.desc.Size {
+			return "", fmt.Errorf("digest or size of the uploaded blob %s doesn't match the App manifest", b.desc.Digest)
+		}
+		if len(b.kind) > 0 {
+			sink.Handle(events.BlobUploaded{Kind: b.kind, Descriptor: b.desc})
+		}
 	}
 
-	blobStore := repo.Blobs(ctx)
-	desc, err := blobStore.Put(ctx, "application/tar+gzip", buff)
+	svc, err := repo.Manifests(ctx, nil)
 	if err != nil {
 		return "", err
 	}
-	fmt.Println("  |-> app blob: ", desc.Digest.String())
 
-	mb := ocischema.NewManifestBuilder(blobStore, []byte{}, map[string]string{"compose-app": "v1"})
-	if err := mb.AppendReference(desc); err != nil {
+	unchanged, err := isManifestPublished(ctx, repo, man, tags)
+	if err != nil {
 		return "", err
 	}
-
-	if appLayersMetaData != nil {
-		if d, err := blobStore.Put(ctx, "application/json", appLayersMetaData); err == nil {
-			d.Annotations = map[string]string{"layers-meta": "v1"}
-			if err := mb.AppendReference(d); err != nil {
-				return "", fmt.Errorf("failed to add App layers meta descriptor to the App manifest: %s", err.Error())
-			}
-			fmt.Println("  |-> app layers meta: ", d.Digest.String())
-		} else {
-			return "", fmt.Errorf("failed to put App layers meta to the App blob store: %s", err.Error())
+	if unchanged {
+		_, payload, err := man.Payload()
+		if err != nil {
+			return "", err
 		}
+		dgst := digest.FromBytes(payload)
+		sink.Handle(events.ManifestPushed{Digest: dgst, Tags: tags, Unchanged: true})
+		return dgst.String(), nil
 	}
 
-	manifest, err := mb.Build(ctx)
+	var putOptions []distribution.ManifestServiceOption
+	if len(tags) > 0 {
+		putOptions = append(putOptions, distribution.WithTag(tags[0]))
+	}
+	digest, err := svc.Put(ctx, man, putOptions...)
 	if err != nil {
 		return "", err
 	}
-
-	man, ok := manifest.(*ocischema.DeserializedManifest)
-	if !ok {
-		return "", fmt.Errorf("invalid manifest type, expected *ocischema.DeserializedManifest, got: %T", manifest)
+	// the registry client can't tag an existing manifest, so the manifest
+	// is put again for each of the other tags
+	for i := 1; i < len(tags); i++ {
+		tag := tags[i]
+		d, err := svc.Put(ctx, man, distribution.WithTag(tag))
+		if err != nil {
+			return "", err
+		}
+		if d != digest {
+			return "", fmt.Errorf("digest of the manifest tagged %s doesn't match; expected: %s, got: %s", tag, digest, d)
+		}
 	}
+	sink.Handle(events.ManifestPushed{Digest: digest, Tags: tags})
+
+	return digest.String(), err
+}
 
-	b, err := man.MarshalJSON()
+// isManifestPublished tells whether the manifest is already in the repo and
+// all the tags point to it
+func isManifestPublished(ctx context.Context, repo distribution.Repository, man distribution.Manifest, tags []string) (bool, error) {
+	_, payload, err := man.Payload()
 	if err != nil {
-		return "", err
+		return false, err
 	}
+	dgst := digest.FromBytes(payload)
+	svc, err := repo.Manifests(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	if exists, err := svc.Exists(ctx, dgst); err != nil || !exists {
+		return false, err
+	}
+	for _, tag := range tags {
+		// a tag that can't be resolved is (re)pushed, so the push reports
+		// an actual problem, if any
+		desc, err := repo.Tags(ctx).Get(ctx, tag)
+		if err != nil || desc.Digest != dgst {
+			return false, nil
+		}
+	}
+	return true, nil
+}
 
-	manMap := make(map[string]interface{})
-	err = json.Unmarshal(b, &manMap)
+// SaveApp writes an App bundle along with the App manifest referring to it
+// to an OCI image layout, exactly as PushApp would publish them to a registry
+func SaveApp(layout *OCILayout, pinned, buff []byte, tags []string, layerManifests []distribution.Descriptor, appLayersMetaData, platformsData []byte, profile CompatProfile, optimize bool, sink events.Sink) (string, error) {
+	man, blobs, err := composeApp(pinned, buff, layerManifests, appLayersMetaData, platformsData, profile, optimize, sink)
 	if err != nil {
 		return "", err
 	}
 
-	manMap["manifests"] = layerManifests
+	for _, b := range blobs {
+		if _, err := layout.WriteBlob(b.desc.MediaType, b.data); err != nil {
+			return "", err
+		}
+		if len(b.kind) > 0 {
+			sink.Handle(events.BlobUploaded{Kind: b.kind, Descriptor: b.desc})
+		}
+	}
 
-	b1, err := json.MarshalIndent(manMap, "", "   ")
+	mediaType, payload, err := man.Payload()
 	if err != nil {
 		return "", err
 	}
+	desc, err := layout.WriteBlob(mediaType, payload)
+	if err != nil {
+		return "", err
+	}
+	if err := layout.WriteIndex(desc, tags); err != nil {
+		return "", err
+	}
+	sink.Handle(events.LayoutWritten{Dir: layout.Dir, Digest: desc.Digest, Tags: tags})
+	return desc.Digest.String(), nil
+}
 
-	err = man.UnmarshalJSON(b1)
+// PushSavedApp publishes an App written to an OCI image layout by SaveApp,
+// `raw` is the App manifest and `layerManifests` are the App layers
+// manifests it refers to. Like PushApp, it doesn't upload the blobs and
+// manifests that are already in the repository.
+func PushSavedApp(ctx context.Context, layout *OCILayout, raw []byte, layerManifests []distribution.Descriptor, target string, tags []string, sink events.Sink) (string, error) {
+	named, err := reference.ParseNormalizedNamed(target)
 	if err != nil {
 		return "", err
 	}
+	man := new(ocischema.DeserializedManifest)
+	if err := man.UnmarshalJSON(raw); err != nil {
+		return "", err
+	}
 
-	fmt.Printf("  |-> manifest size: %d\n", len(b1))
-	// TODO: this check is needed in order to overcome the aklite's check on the maximum manifest size (2048)
-	// Once the new version of aklite is deployed (max manifest size = 16K) then this check can be removed or MaxArchNumb increased
-	if len(b1) >= MaxManifestBodySize {
-		return "", fmt.Errorf("app manifest size (%d) exceeds the maximum size limit (%d)", len(b1), MaxManifestBodySize)
+	regc := NewRegistryClient()
+	repo, err := regc.GetRepository(ctx, named)
+	if err != nil {
+		return "", err
 	}
 	svc, err := repo.Manifests(ctx, nil)
 	if err != nil {
 		return "", err
 	}
 
-	putOptions := []distribution.ManifestServiceOption{distribution.WithTag(tag)}
-	digest, err := svc.Put(ctx, man, putOptions...)
-	if err != nil {
-		return "", err
+	sink.Handle(events.StageStarted{Stage: "Posting app layers manifests"})
+	for _, desc := range layerManifests {
+		arch := ""
+		if desc.Platform != nil {
+			arch = LayersManifestKey(desc.Platform.Architecture, desc.Platform.Variant)
+		}
+		if exists, err := svc.Exists(ctx, desc.Digest); err != nil {
+			return "", err
+		} else if exists {
+			sink.Handle(events.LayerManifestPosted{Arch: arch, Descriptor: desc, Unchanged: true})
+			continue
+		}
+		b, err := layout.ReadBlob(desc)
+		if err != nil {
+			return "", err
+		}
+		layersMan, _, err := distribution.UnmarshalManifest(desc.MediaType, b)
+		if err != nil {
+			return "", err
+		}
+		d, err := svc.Put(ctx, layersMan)
+		if err != nil {
+			return "", err
+		}
+		if d != desc.Digest {
+			return "", fmt.Errorf("digest of the posted manifest doesn't match; expected: %s, got: %s", desc.Digest, d)
+		}
+		sink.Handle(events.LayerManifestPosted{Arch: arch, Descriptor: desc})
 	}
-	fmt.Println("  |-> manifest: ", digest.String())
 
-	return digest.String(), err
+	sink.Handle(events.StageStarted{Stage: "Publishing app"})
+	blobs := make([]appBlob, 0, len(man.Layers)+1)
+	for i, desc := range append([]distribution.Descriptor{man.Config}, man.Layers...) {
+		b, err := layout.ReadBlob(desc)
+		if err != nil {
+			return "", err
+		}
+		blob := appBlob{desc: desc, data: b}
+		if i > 0 {
+			blob.kind = appBlobKind(desc)
+		}
+		blobs = append(blobs, blob)
+	}
+	return pushApp(ctx, repo, man, blobs, tags, sink)
+}
+
+// appBlobKind returns the kind of a blob referred to by an App manifest as
+// it is reported by events
+func appBlobKind(desc distribution.Descriptor) string {
+	switch {
+	case desc.MediaType == BundleMediaType:
+		return events.BlobBundle
+	case len(desc.Annotations["layers-meta"]) > 0:
+		return events.BlobLayersMeta
+	case len(desc.Annotations["layers-index"]) > 0:
+		return events.BlobLayersIndex
+	case len(desc.Annotations["platforms"]) > 0:
+		return events.BlobPlatforms
+	}
+	return ""
 }
@@ -6,6 +6,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/docker/cli/cli/config"
@@ -27,6 +28,58 @@ type RegistryClient struct {
 	authConfigResolver AuthConfigResolver
 	insecureRegistry   bool
 	userAgent          string
+	transports         *transportCache
+}
+
+// transportCache keeps authorized transports per registry repo, so that
+// the registry is pinged and a token is obtained once per repo rather than
+// each time a repository is obtained, it's safe for concurrent use
+type transportCache struct {
+	mu         sync.Mutex
+	transports map[string]*cachedTransport
+}
+
+// cachedTransport is a transport that is being created or is created already,
+// ready is closed once it's done
+type cachedTransport struct {
+	ready     chan struct{}
+	transport http.RoundTripper
+	err       error
+}
+
+// defaultTransportCache is shared by all registry clients
+var defaultTransportCache = &transportCache{transports: make(map[string]*cachedTransport)}
+
+// get returns the transport cached for a key, or creates it. The lock is held
+// only to look up and store the entry, callers asking for a transport that is
+// being created wait for it rather than create another one. A transport that
+// failed to be created is not cached, so the next caller tries it again.
+func (c *transportCache) get(ctx context.Context, key string, create func() (http.RoundTripper, error)) (http.RoundTripper, error) {
+	c.mu.Lock()
+	entry, ok := c.transports[key]
+	if !ok {
+		entry = &cachedTransport{ready: make(chan struct{})}
+		c.transports[key] = entry
+	}
+	c.mu.Unlock()
+
+	if ok {
+		select {
+		case <-entry.ready:
+			return entry.transport, entry.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	entry.transport, entry.err = create()
+	if entry.err != nil {
+		c.mu.Lock()
+		delete(c.transports, key)
+		c.mu.Unlock()
+	}
+	close(entry.ready)
+	return entry.transport, entry.err
 }
 
 func ResolveAuthConfig(ctx context.Context, index *registrytypes.IndexInfo) types.AuthConfig {
@@ -44,6 +97,7 @@ func NewRegistryClient() RegistryClient {
 		authConfigResolver: resolver,
 		insecureRegistry:   false,
 		userAgent:          "Compose-Ref",
+		transports:         defaultTransportCache,
 	}
 }
 
@@ -65,16 +119,36 @@ func (c *RegistryClient) getRepositoryForReference(ctx context.Context, ref refe
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to parse repo name from %s", ref)
 	}
-	return distributionclient.NewRepository(repoName, repoEndpoint.BaseURL(), httpTransport)
+	return distributionclient.NewRepository(repoName, repoEndpoint.BaseURL(), &contextTransport{ctx: ctx, base: httpTransport})
+}
+
+// contextTransport binds registry requests to the context the repository was
+// obtained with, so callers can cancel or time out registry operations
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
 }
 
 func (c *RegistryClient) getHTTPTransportForRepoEndpoint(ctx context.Context, repoEndpoint repositoryEndpoint) (http.RoundTripper, error) {
-	httpTransport, err := getHTTPTransport(
-		c.authConfigResolver(ctx, repoEndpoint.info.Index),
-		repoEndpoint.endpoint,
-		repoEndpoint.Name(),
-		c.userAgent)
-	return httpTransport, errors.Wrap(err, "failed to configure transport")
+	create := func() (http.RoundTripper, error) {
+		httpTransport, err := getHTTPTransport(
+			c.authConfigResolver(ctx, repoEndpoint.info.Index),
+			repoEndpoint.endpoint,
+			repoEndpoint.Name(),
+			c.userAgent)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to configure transport")
+		}
+		return httpTransport, nil
+	}
+	if c.transports == nil {
+		return create()
+	}
+	return c.transports.get(ctx, repoEndpoint.BaseURL()+"/"+repoEndpoint.Name(), create)
 }
 
 // getHTTPTransport builds a transport for use in communicating with a registry
@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransportCacheCreatesOnce(t *testing.T) {
+	cache := &transportCache{transports: make(map[string]*cachedTransport)}
+	var created int32
+	create := func() (http.RoundTripper, error) {
+		atomic.AddInt32(&created, 1)
+		// keep the creation in progress while the other callers ask for it
+		time.Sleep(10 * time.Millisecond)
+		return http.DefaultTransport, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if tr, err := cache.get(context.Background(), "repo", create); err != nil || tr != http.DefaultTransport {
+				t.Errorf("unexpected transport: %v, err: %v", tr, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if created != 1 {
+		t.Errorf("transport is created %d times, expected once", created)
+	}
+}
+
+func TestTransportCacheDoesNotBlockOtherKeys(t *testing.T) {
+	cache := &transportCache{transports: make(map[string]*cachedTransport)}
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go cache.get(context.Background(), "slow", func() (http.RoundTripper, error) {
+		close(started)
+		<-release
+		return http.DefaultTransport, nil
+	})
+	defer close(release)
+	<-started
+
+	done := make(chan struct{})
+	go func() {
+		cache.get(context.Background(), "fast", func() (http.RoundTripper, error) { return http.DefaultTransport, nil })
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("creating a transport blocks the other repos")
+	}
+}
+
+func TestTransportCacheRetriesFailures(t *testing.T) {
+	cache := &transportCache{transports: make(map[string]*cachedTransport)}
+	if _, err := cache.get(context.Background(), "repo", func() (http.RoundTripper, error) {
+		return nil, errors.New("registry is down")
+	}); err == nil {
+		t.Fatal("expected the creation error")
+	}
+	tr, err := cache.get(context.Background(), "repo", func() (http.RoundTripper, error) {
+		return http.DefaultTransport, nil
+	})
+	if err != nil || tr != http.DefaultTransport {
+		t.Errorf("a failed transport is cached: %v, err: %v", tr, err)
+	}
+}
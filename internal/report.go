@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/foundriesio/compose-publish/pkg/events"
+)
+
+type (
+	ServiceReport struct {
+		Image       string `json:"image"`
+		PinnedImage string `json:"pinned_image,omitempty"`
+		ConfigHash  string `json:"config_hash,omitempty"`
+		// ImageTemplate is the image as defined in the compose file if it refers to variables
+		ImageTemplate string            `json:"image_template,omitempty"`
+		ImageEnv      map[string]string `json:"image_env,omitempty"`
+		// Platforms are the platforms the service image is available for
+		Platforms []string `json:"platforms,omitempty"`
+	}
+	BlobReport struct {
+		Digest digest.Digest `json:"digest"`
+		Size   int64         `json:"size"`
+	}
+	ManifestReport struct {
+		Digest    digest.Digest `json:"digest,omitempty"`
+		Size      int           `json:"size"`
+		SizeLimit int           `json:"size_limit"`
+		// Headroom is how many bytes the manifest can grow by and still be accepted
+		Headroom int `json:"headroom"`
+		// CompatProfile is the device compatibility profile the manifest is built for
+		CompatProfile string `json:"compat_profile"`
+		// Reductions are the size reductions applied to the manifest to fit into the limit
+		Reductions []string `json:"reductions,omitempty"`
+	}
+	// PublishReport is a machine-readable summary of an App publishing
+	PublishReport struct {
+		Target           string                    `json:"target"`
+		DryRun           bool                      `json:"dry_run"`
+		EnvFiles         []string                  `json:"env_files"`
+		Services         map[string]*ServiceReport `json:"services"`
+		ExcludedServices map[string][]string       `json:"excluded_services"`
+		Architectures    []string                  `json:"architectures"`
+		// Platforms are the included platforms in the `os/arch[/variant]` form
+		Platforms             []string          `json:"platforms"`
+		ExcludedArchitectures map[string]string `json:"excluded_architectures"`
+		// Attestations maps services to the attestation manifests of their images
+		Attestations map[string][]digest.Digest `json:"attestations,omitempty"`
+		// LayerManifests are keyed by the same architectures as Architectures
+		LayerManifests map[string]digest.Digest `json:"layer_manifests"`
+		Bundle         *BlobReport              `json:"bundle,omitempty"`
+		LayersMeta     *BlobReport              `json:"layers_meta,omitempty"`
+		Manifest       *ManifestReport          `json:"manifest,omitempty"`
+		// Tags the App manifest is tagged with, empty if published by digest only
+		Tags []string `json:"tags"`
+		// Unchanged is set if the App was already published with the same manifest and tags
+		Unchanged bool `json:"unchanged"`
+		// Layout is the OCI image layout directory the App is written to for a dry run
+		Layout string `json:"layout,omitempty"`
+
+		// publishedArchs maps the platform keys the App layers manifests are
+		// published with to the architectures they are reported with
+		publishedArchs map[string]string
+	}
+)
+
+func NewPublishReport(target string, dryRun bool) *PublishReport {
+	return &PublishReport{
+		Target:                target,
+		DryRun:                dryRun,
+		EnvFiles:              []string{},
+		Services:              make(map[string]*ServiceReport),
+		ExcludedServices:      make(map[string][]string),
+		Architectures:         []string{},
+		Platforms:             []string{},
+		ExcludedArchitectures: make(map[string]string),
+		Attestations:          make(map[string][]digest.Digest),
+		LayerManifests:        make(map[string]digest.Digest),
+		Tags:                  []string{},
+		publishedArchs:        make(map[string]string),
+	}
+}
+
+func (r *PublishReport) service(name string) *ServiceReport {
+	svc, ok := r.Services[name]
+	if !ok {
+		svc = &ServiceReport{}
+		r.Services[name] = svc
+	}
+	return svc
+}
+
+// Handle makes the report an events.Sink, it is populated by publishing events
+func (r *PublishReport) Handle(event events.Event) {
+	switch e := event.(type) {
+	case events.ImagePinned:
+		svc := r.service(e.Service)
+		svc.Image = e.Image
+		svc.PinnedImage = e.Pinned
+	case events.EnvLoaded:
+		r.EnvFiles = append(r.EnvFiles, e.Files...)
+	case events.ImageEnvResolved:
+		if len(e.Env) > 0 {
+			svc := r.service(e.Service)
+			svc.ImageTemplate = e.Image
+			svc.ImageEnv = e.Env
+		}
+	case events.ServiceExcluded:
+		r.ExcludedServices[e.Service] = e.Profiles
+	case events.ConfigHashed:
+		r.service(e.Service).ConfigHash = e.Hash
+	case events.ArchIncluded:
+		r.Architectures = append(r.Architectures, e.Arch)
+		sort.Strings(r.Architectures)
+		r.Platforms = append(r.Platforms, e.OS+"/"+e.Arch)
+		sort.Strings(r.Platforms)
+		r.publishedArchs[e.PublishedAs] = e.Arch
+	case events.ServicePlatforms:
+		r.service(e.Service).Platforms = e.Platforms
+	case events.AttestationSkipped:
+		r.Attestations[e.Service] = append(r.Attestations[e.Service], e.Digest)
+	case events.ArchExcluded:
+		r.ExcludedArchitectures[e.Arch] = e.Reason
+	case events.LayerManifestPosted:
+		arch := e.Arch
+		if a, ok := r.publishedArchs[e.Arch]; ok {
+			arch = a
+		}
+		r.LayerManifests[arch] = e.Descriptor.Digest
+	case events.BundleCreated:
+		r.Bundle = &BlobReport{Digest: e.Digest, Size: e.Size}
+	case events.BlobUploaded:
+		if e.Kind == events.BlobLayersMeta {
+			r.LayersMeta = &BlobReport{Digest: e.Descriptor.Digest, Size: e.Descriptor.Size}
+		}
+	case events.ManifestBuilt:
+		r.Manifest = &ManifestReport{
+			Digest:    e.Digest,
+			Size:      e.Size,
+			SizeLimit: e.SizeLimit,
+			// a manifest of the limit size is rejected already
+			Headroom:      e.SizeLimit - e.Size - 1,
+			CompatProfile: e.CompatProfile,
+			Reductions:    e.Reductions,
+		}
+	case events.ManifestPushed:
+		r.Tags = append(r.Tags, e.Tags...)
+		r.Unchanged = e.Unchanged
+	case events.LayoutWritten:
+		r.Layout = e.Dir
+		r.Tags = append(r.Tags, e.Tags...)
+	}
+}
+
+func (r *PublishReport) Marshal() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+func (r *PublishReport) WriteFile(path string) error {
+	b, err := r.Marshal()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0o640)
+}
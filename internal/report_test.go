@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/foundriesio/compose-publish/pkg/events"
+)
+
+func TestPublishReportArchitectureKeys(t *testing.T) {
+	r := NewPublishReport("hub.example/factory/app", false)
+	v6 := digest.FromString("arm/v6")
+	amd64 := digest.FromString("amd64")
+	for _, e := range []events.Event{
+		events.ArchIncluded{Arch: "amd64", OS: "linux", PublishedAs: "amd64"},
+		events.ArchIncluded{Arch: "arm/v6", OS: "linux", PublishedAs: "arm"},
+		events.LayerManifestPosted{Arch: "amd64", Descriptor: distribution.Descriptor{Digest: amd64}},
+		events.LayerManifestPosted{Arch: "arm", Descriptor: distribution.Descriptor{Digest: v6}},
+	} {
+		r.Handle(e)
+	}
+	if expected := []string{"amd64", "arm/v6"}; !reflect.DeepEqual(r.Architectures, expected) {
+		t.Errorf("unexpected architectures; expected: %q, got: %q", expected, r.Architectures)
+	}
+	if expected := []string{"linux/amd64", "linux/arm/v6"}; !reflect.DeepEqual(r.Platforms, expected) {
+		t.Errorf("unexpected platforms; expected: %q, got: %q", expected, r.Platforms)
+	}
+	if expected := map[string]digest.Digest{"amd64": amd64, "arm/v6": v6}; !reflect.DeepEqual(r.LayerManifests, expected) {
+		t.Errorf("unexpected layer manifests; expected: %v, got: %v", expected, r.LayerManifests)
+	}
+}
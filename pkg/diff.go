@@ -0,0 +1,300 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/compose-spec/compose-go/loader"
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/foundriesio/compose-publish/internal"
+)
+
+// appSnapshot is content of an App that is relevant for comparing two
+// versions of it, either published or not yet published
+type appSnapshot struct {
+	services map[string]interface{}
+	files    map[string]digest.Digest
+	layers   map[string][]distribution.Descriptor
+}
+
+func newAppSnapshot(bundle []byte, layers map[string][]distribution.Descriptor) (*appSnapshot, error) {
+	files, err := internal.ReadBundle(bundle)
+	if err != nil {
+		return nil, err
+	}
+	composeContent, ok := files["docker-compose.yml"]
+	if !ok {
+		return nil, errors.New("App bundle doesn't contain docker-compose.yml")
+	}
+	config, err := loader.ParseYAML(composeContent)
+	if err != nil {
+		return nil, err
+	}
+	svcs, ok := config["services"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Unable to find 'services' section of compose file")
+	}
+
+	snapshot := &appSnapshot{
+		services: svcs,
+		files:    make(map[string]digest.Digest),
+		layers:   layers,
+	}
+	for name, content := range files {
+		snapshot.files[name] = digest.FromBytes(content)
+	}
+	return snapshot, nil
+}
+
+func getPublishedSnapshot(ctx context.Context, ref string) (*appSnapshot, error) {
+	app, err := internal.GetPublishedApp(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	bundleDesc, err := app.BundleDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := app.FetchBlob(ctx, bundleDesc)
+	if err != nil {
+		return nil, err
+	}
+	layersManifests, err := app.LayersManifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	layers := make(map[string][]distribution.Descriptor)
+	for _, desc := range layersManifests {
+		layersMan, err := app.FetchLayersManifest(ctx, desc)
+		if err != nil {
+			return nil, err
+		}
+		if layersMan.Platform == nil {
+			return nil, fmt.Errorf("app layers manifest %s doesn't specify a platform", desc.Digest)
+		}
+		// the same keys as of the layers of a local App
+		layers[internal.LayersManifestKey(layersMan.Platform.Architecture, layersMan.Platform.Variant)] = layersMan.Layers
+	}
+	return newAppSnapshot(bundle, layers)
+}
+
+func getLocalSnapshot(ctx context.Context, appDir string) (*appSnapshot, error) {
+	loaded, err := LoadApp(ctx, PublishOptions{ProjectDir: appDir})
+	if err != nil {
+		return nil, err
+	}
+	pinned, err := PinImages(ctx, loaded)
+	if err != nil {
+		return nil, err
+	}
+	hashed, err := HashConfigs(ctx, pinned)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := ResolveLayers(ctx, hashed)
+	if err != nil {
+		return nil, err
+	}
+	_, bundle, err := internal.CreateBundle(resolved.Config, resolved.Options.ProjectDir, resolved.Options.ComposeFiles)
+	if err != nil {
+		return nil, err
+	}
+	return newAppSnapshot(bundle, resolved.Layers)
+}
+
+func getSnapshot(ctx context.Context, ref string) (*appSnapshot, error) {
+	if fi, err := os.Stat(ref); err == nil && fi.IsDir() {
+		fmt.Printf("= Preparing local app %s...\n", ref)
+		return getLocalSnapshot(ctx, ref)
+	}
+	fmt.Printf("= Fetching published app %s...\n", ref)
+	return getPublishedSnapshot(ctx, ref)
+}
+
+// serviceLabel returns a label value of a service config as it is defined
+// in the compose file, labels can be either a map or a list of `key=value`
+func serviceLabel(svc map[string]interface{}, label string) string {
+	switch labels := svc["labels"].(type) {
+	case map[string]interface{}:
+		if v, ok := labels[label]; ok {
+			return fmt.Sprint(v)
+		}
+	case []interface{}:
+		for _, l := range labels {
+			if parts := strings.SplitN(fmt.Sprint(l), "=", 2); len(parts) == 2 && parts[0] == label {
+				return parts[1]
+			}
+		}
+	}
+	return ""
+}
+
+func serviceImage(svc map[string]interface{}) string {
+	image, _ := svc["image"].(string)
+	return image
+}
+
+func sortedMapKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func diffServices(a, b map[string]interface{}) {
+	fmt.Println("= Services:")
+	changed := false
+	for _, name := range sortedMapKeys(a) {
+		if _, ok := b[name]; !ok {
+			changed = true
+			fmt.Printf("  |-> - %s\n", name)
+		}
+	}
+	for _, name := range sortedMapKeys(b) {
+		svcB, _ := b[name].(map[string]interface{})
+		obj, ok := a[name]
+		if !ok {
+			changed = true
+			fmt.Printf("  |-> + %s (%s)\n", name, serviceImage(svcB))
+			continue
+		}
+		svcA, _ := obj.(map[string]interface{})
+		if imgA, imgB := serviceImage(svcA), serviceImage(svcB); imgA != imgB {
+			changed = true
+			fmt.Printf("  |-> ~ %s image: %s -> %s\n", name, imgA, imgB)
+		}
+		hashLabel := "io.compose-spec.config-hash"
+		if hashA, hashB := serviceLabel(svcA, hashLabel), serviceLabel(svcB, hashLabel); hashA != hashB {
+			changed = true
+			fmt.Printf("  |-> ~ %s config hash: %s -> %s\n", name, hashA, hashB)
+		}
+	}
+	if !changed {
+		fmt.Println("  |-> no changes")
+	}
+}
+
+func diffFiles(a, b map[string]digest.Digest) {
+	fmt.Println("= Bundle files:")
+	names := make(map[string]bool)
+	for name := range a {
+		names[name] = true
+	}
+	for name := range b {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	changed := false
+	for _, name := range sorted {
+		dA, inA := a[name]
+		dB, inB := b[name]
+		switch {
+		case !inA:
+			changed = true
+			fmt.Printf("  |-> + %s\n", name)
+		case !inB:
+			changed = true
+			fmt.Printf("  |-> - %s\n", name)
+		case dA != dB:
+			changed = true
+			fmt.Printf("  |-> ~ %s\n", name)
+		}
+	}
+	if !changed {
+		fmt.Println("  |-> no changes")
+	}
+}
+
+func diffLayers(a, b map[string][]distribution.Descriptor) {
+	fmt.Println("= Layers:")
+	archs := make(map[string]bool)
+	for arch := range a {
+		archs[arch] = true
+	}
+	for arch := range b {
+		archs[arch] = true
+	}
+	sorted := make([]string, 0, len(archs))
+	for arch := range archs {
+		sorted = append(sorted, arch)
+	}
+	sort.Strings(sorted)
+
+	for _, arch := range sorted {
+		layersA, inA := a[arch]
+		layersB, inB := b[arch]
+		switch {
+		case !inA:
+			fmt.Printf("  |-> + %s architecture\n", arch)
+		case !inB:
+			fmt.Printf("  |-> - %s architecture\n", arch)
+		}
+
+		inLayersA := make(map[digest.Digest]bool)
+		for _, l := range layersA {
+			inLayersA[l.Digest] = true
+		}
+		inLayersB := make(map[digest.Digest]bool)
+		for _, l := range layersB {
+			inLayersB[l.Digest] = true
+		}
+
+		var added, removed []distribution.Descriptor
+		var addedSize, removedSize int64
+		for _, l := range layersB {
+			if !inLayersA[l.Digest] {
+				added = append(added, l)
+				addedSize += l.Size
+			}
+		}
+		for _, l := range layersA {
+			if !inLayersB[l.Digest] {
+				removed = append(removed, l)
+				removedSize += l.Size
+			}
+		}
+
+		fmt.Printf("  |-> %s: +%d layers (%d bytes), -%d layers (%d bytes)\n",
+			arch, len(added), addedSize, len(removed), removedSize)
+		for _, l := range added {
+			fmt.Printf("     |-> + %s (%d bytes)\n", l.Digest, l.Size)
+		}
+		for _, l := range removed {
+			fmt.Printf("     |-> - %s (%d bytes)\n", l.Digest, l.Size)
+		}
+	}
+}
+
+// DoDiff compares two versions of an App, each of them can be either a
+// reference to a published App or a local App directory
+func DoDiff(refA, refB string) error {
+	ctx := context.Background()
+
+	a, err := getSnapshot(ctx, refA)
+	if err != nil {
+		return err
+	}
+	b, err := getSnapshot(ctx, refB)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("= Diff %s -> %s\n", refA, refB)
+	diffServices(a.services, b.services)
+	diffFiles(a.files, b.files)
+	diffLayers(a.layers, b.layers)
+	return nil
+}
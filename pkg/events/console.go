@@ -0,0 +1,103 @@
+package events
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Console prints events in a human-readable form
+type Console struct {
+	// Out is where events are printed to, os.Stdout if not set
+	Out io.Writer
+}
+
+func (c *Console) Handle(event Event) {
+	out := c.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	switch e := event.(type) {
+	case StageStarted:
+		fmt.Fprintf(out, "= %s...\n", e.Stage)
+	case Warning:
+		fmt.Fprintf(out, "= %s\n", e.Message)
+	case EnvLoaded:
+		for _, f := range e.Files {
+			fmt.Fprintf(out, "  |-> env file: %s\n", f)
+		}
+	case ServiceExcluded:
+		fmt.Fprintf(out, "  |-> exclude service %s, none of its profiles %q is active\n", e.Service, e.Profiles)
+	case BuildRemoved:
+		fmt.Fprintf(out, "Removing service(%s) 'build' stanza\n", e.Service)
+	case ImagePinned:
+		fmt.Fprintf(out, "Pinning %s(%s)\n", e.Service, e.Image)
+		if len(e.Platforms) > 0 {
+			fmt.Fprintf(out, "  | %s\n", strings.Join(e.Platforms, ", "))
+		}
+		fmt.Fprintf(out, "  |-> %s\n", e.Pinned)
+	case ConfigHashed:
+		fmt.Fprintf(out, "   |-> %s : %s\n", e.Service, e.Hash)
+	case ServicePlatforms:
+		fmt.Fprintf(out, "  |-> %s platforms: %s\n", e.Service, strings.Join(e.Platforms, ", "))
+	case AttestationSkipped:
+		fmt.Fprintf(out, "  |-> skipping %s attestation manifest: %s\n", e.Service, e.Digest)
+	case ArchIncluded:
+		if len(e.PublishedAs) > 0 && e.PublishedAs != e.Arch {
+			fmt.Fprintf(out, "  |-> getting app layers for architecture: %s (%s), published as: %s\n", e.Arch, e.OS, e.PublishedAs)
+		} else {
+			fmt.Fprintf(out, "  |-> getting app layers for architecture: %s (%s)\n", e.Arch, e.OS)
+		}
+	case ArchExcluded:
+		fmt.Fprintf(out, "  |-> exclude  %s architecture, %s\n", e.Arch, e.Reason)
+	case LayerManifestPosted:
+		if e.DryRun {
+			fmt.Fprintf(out, "  |-> skipping layer manifest publishing for dryrun: %s, digest: %s\n", e.Arch, e.Descriptor.Digest)
+		} else if e.Unchanged {
+			fmt.Fprintf(out, "  |-> layer manifest for architecture: %s is unchanged, digest: %s\n", e.Arch, e.Descriptor.Digest)
+		} else {
+			fmt.Fprintf(out, "  |-> posted a layer manifest for architecture: %s, digest: %s\n", e.Arch, e.Descriptor.Digest)
+		}
+	case BundleCreated:
+		fmt.Fprintf(out, "  |-> pinned content hash: %s\n", e.ComposeDigest.Encoded())
+		fmt.Fprintf(out, "  |-> app archive hash: %s\n", e.Digest.Encoded())
+	case PublishSkipped:
+		fmt.Fprintln(out, "Pinned compose:")
+		fmt.Fprintln(out, string(e.Compose))
+		fmt.Fprintln(out, "Skipping publishing for dryrun")
+	case BlobUploaded:
+		unchanged := ""
+		if e.Unchanged {
+			unchanged = " (unchanged)"
+		}
+		switch e.Kind {
+		case BlobLayersMeta:
+			fmt.Fprintln(out, "  |-> app layers meta: ", e.Descriptor.Digest.String()+unchanged)
+		case BlobPlatforms:
+			fmt.Fprintln(out, "  |-> app platforms: ", e.Descriptor.Digest.String()+unchanged)
+		case BlobLayersIndex:
+			fmt.Fprintln(out, "  |-> app layers index: ", e.Descriptor.Digest.String()+unchanged)
+		default:
+			fmt.Fprintln(out, "  |-> app blob: ", e.Descriptor.Digest.String()+unchanged)
+		}
+	case ManifestBuilt:
+		fmt.Fprintf(out, "  |-> manifest size: %d (limit: %d, profile: %s)\n", e.Size, e.SizeLimit, e.CompatProfile)
+		if len(e.Reductions) > 0 {
+			fmt.Fprintf(out, "  |-> size reductions: %s\n", strings.Join(e.Reductions, ", "))
+		}
+	case ManifestPushed:
+		if e.Unchanged {
+			fmt.Fprintln(out, "  |-> manifest: ", e.Digest.String(), "(unchanged)")
+		} else {
+			fmt.Fprintln(out, "  |-> manifest: ", e.Digest.String())
+		}
+		if len(e.Tags) > 0 {
+			fmt.Fprintf(out, "  |-> tags: %s\n", strings.Join(e.Tags, ", "))
+		}
+	case LockWritten:
+		fmt.Fprintf(out, "  |-> wrote lock file %s\n", e.File)
+	case LayoutWritten:
+		fmt.Fprintf(out, "  |-> wrote OCI image layout to %s, manifest: %s\n", e.Dir, e.Digest)
+	}
+}
@@ -0,0 +1,158 @@
+// Package events defines progress events emitted while an App is published
+// and sinks that consume them
+package events
+
+import (
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+)
+
+// Kinds of blobs reported by BlobUploaded
+const (
+	BlobBundle     = "bundle"
+	BlobLayersMeta = "layers-meta"
+	// BlobLayersIndex lists the App layers manifests if they don't fit into the App manifest
+	BlobLayersIndex = "layers-index"
+	BlobPlatforms   = "platforms"
+)
+
+type (
+	// Event is one of the event types defined in this package
+	Event interface{}
+
+	// Sink consumes events, Handle is called synchronously by the publisher
+	Sink interface {
+		Handle(event Event)
+	}
+
+	// SinkFunc adapts a function to the Sink interface
+	SinkFunc func(event Event)
+
+	// Multi forwards events to each of its sinks
+	Multi []Sink
+)
+
+type (
+	StageStarted struct {
+		Stage string
+	}
+	Warning struct {
+		Message string
+	}
+	EnvLoaded struct {
+		// Files are env files the compose environment was loaded from
+		Files []string
+	}
+	ImageEnvResolved struct {
+		Service string
+		// Image is the image reference as it is defined in the compose file
+		Image string
+		// Env holds the variables referenced by Image along with their values
+		Env map[string]string
+	}
+	ServiceExcluded struct {
+		Service string
+		// Profiles of the service, none of them is active
+		Profiles []string
+	}
+	BuildRemoved struct {
+		Service string
+	}
+	ImagePinned struct {
+		Service string
+		Image   string
+		Pinned  string
+		// Platforms of a multi-platform image, empty for a single-platform one
+		Platforms []string
+	}
+	ConfigHashed struct {
+		Service string
+		Hash    string
+	}
+	// ServicePlatforms is emitted for each service with the platforms, in the
+	// `os/arch[/variant]` form, its image is available for
+	ServicePlatforms struct {
+		Service   string
+		Platforms []string
+	}
+	// AttestationSkipped is emitted for an attestation manifest of a service
+	// image, it is not taken into account when resolving the App layers
+	AttestationSkipped struct {
+		Service string
+		Digest  digest.Digest
+		// Type is the attestation reference type, if annotated
+		Type string
+	}
+	ArchIncluded struct {
+		Arch string
+		OS   string
+		// PublishedAs is the platform key the App layers manifest of the
+		// architecture is published with, it omits the variant unless the App
+		// supports several variants of the architecture
+		PublishedAs string
+	}
+	ArchExcluded struct {
+		Arch   string
+		Reason string
+	}
+	LayerManifestPosted struct {
+		Arch       string
+		Descriptor distribution.Descriptor
+		// DryRun is set if the manifest was composed but not posted
+		DryRun bool
+		// Unchanged is set if the manifest was not posted since it's already in the repo
+		Unchanged bool
+	}
+	BundleCreated struct {
+		ComposeDigest digest.Digest
+		Digest        digest.Digest
+		Size          int64
+	}
+	PublishSkipped struct {
+		Compose []byte
+	}
+	BlobUploaded struct {
+		Kind       string
+		Descriptor distribution.Descriptor
+		// Unchanged is set if the blob was not uploaded since it's already in the repo
+		Unchanged bool
+	}
+	ManifestBuilt struct {
+		Digest digest.Digest
+		Size   int
+		// SizeLimit is the maximum manifest size allowed by the compatibility profile
+		SizeLimit     int
+		CompatProfile string
+		// Reductions are the size reductions applied to make the manifest fit into the limit
+		Reductions []string
+	}
+	ManifestPushed struct {
+		Digest digest.Digest
+		// Tags the manifest is tagged with, none if pushed by digest only
+		Tags []string
+		// Unchanged is set if the manifest was not pushed since it's already
+		// in the repo and tagged with all the tags
+		Unchanged bool
+	}
+	LockWritten struct {
+		File string
+	}
+	LayoutWritten struct {
+		// Dir is the OCI image layout directory
+		Dir    string
+		Digest digest.Digest
+		Tags   []string
+	}
+)
+
+func (f SinkFunc) Handle(event Event) {
+	f(event)
+}
+
+func (m Multi) Handle(event Event) {
+	for _, s := range m {
+		if s != nil {
+			s.Handle(event)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"sort"
 
 	"github.com/foundriesio/compose-publish/internal"
+	"github.com/foundriesio/compose-publish/pkg/events"
 
 	"github.com/compose-spec/compose-go/types"
 	"github.com/distribution/distribution/v3/reference"
@@ -55,19 +56,24 @@ func GetAppLayers(ctx context.Context, services map[string]types.ServiceConfig,
 	for svc, svcCfg := range services {
 		svcImages[svc] = svcCfg.Image
 	}
-	return GetAppLayersFromMap(ctx, svcImages, archList)
+	return GetAppLayersFromMap(ctx, svcImages, nil, nil, archList, internal.DefaultOS, &events.Console{})
 }
 
-func GetLayers(ctx context.Context, services map[string]interface{}, archList []string) (map[string][]distribution.Descriptor, error) {
+func GetLayers(ctx context.Context, services map[string]interface{}, sources internal.ImageSources, lock *internal.Lock, archList []string, targetOS string, sink events.Sink) (map[string][]distribution.Descriptor, error) {
 	svcImages := make(map[string]string)
 	for svc, cfg := range services {
 		svcCfg := cfg.(map[string]interface{})
 		svcImages[svc] = svcCfg["image"].(string)
 	}
-	return GetAppLayersFromMap(ctx, svcImages, archList)
+	return GetAppLayersFromMap(ctx, svcImages, sources, lock, archList, targetOS, sink)
 }
 
-func GetAppLayersFromMap(ctx context.Context, svcImages map[string]string, archList []string) (map[string][]distribution.Descriptor, error) {
+// GetAppLayersFromMap returns the App layers per architecture of the given
+// OS, keyed as they are published, see internal.PublishedPlatformKeys. Images
+// that have a source among the given image sources are resolved from it. The
+// image manifests and the architectures are recorded to, or checked against,
+// the lock if it's not nil.
+func GetAppLayersFromMap(ctx context.Context, svcImages map[string]string, sources internal.ImageSources, lock *internal.Lock, archList []string, targetOS string, sink events.Sink) (map[string][]distribution.Descriptor, error) {
 	regClient := internal.NewRegistryClient()
 
 	// Get manifests per architecture and per image, for one architecture there should be one manifest for each service image
@@ -75,13 +81,20 @@ func GetAppLayersFromMap(ctx context.Context, svcImages map[string]string, archL
 	// Thus, we have to created a map of maps, arch -> image/manifest-service -> manifest-digest, to avoid inclusion
 	// more then manifest per image and per architecture
 	archToManifestList := make(ArchManifestServices)
-	for _, image := range svcImages {
+	// platforms of each service image, regardless of their OS
+	svcPlatforms := make(map[string]map[string]bool)
+	for svc, image := range svcImages {
+		svcPlatforms[svc] = make(map[string]bool)
 		imageRef, err := reference.ParseNamed(image)
 		if err != nil {
 			return nil, err
 		}
 
-		imageManifestSvc, err := GetManifestService(ctx, regClient, imageRef)
+		imageRepo, err := sources.GetRepository(ctx, &regClient, imageRef)
+		if err != nil {
+			return nil, err
+		}
+		imageManifestSvc, err := imageRepo.Manifests(ctx, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -91,30 +104,58 @@ func GetAppLayersFromMap(ctx context.Context, svcImages map[string]string, archL
 			return nil, err
 		}
 
+		// a docker manifest list or an OCI image index
 		populateFromList := func(manifestList *manifestlist.DeserializedManifestList) {
 			for _, manifest := range manifestList.Manifests {
-				if _, ok := archToManifestList[manifest.Platform.Architecture]; !ok {
-					archToManifestList[manifest.Platform.Architecture] = make(map[distribution.ManifestService]digest.Digest)
+				if internal.IsAttestation(manifest.Platform.Architecture, manifest.Platform.OS, manifest.Annotations) {
+					sink.Handle(events.AttestationSkipped{
+						Service: svc,
+						Digest:  manifest.Digest,
+						Type:    manifest.Annotations[internal.ReferenceTypeAnnotation],
+					})
+					continue
+				}
+				// an OCI image index doesn't have to specify platforms of its manifests,
+				// and nested indexes are not supported by devices
+				if len(manifest.Platform.Architecture) == 0 || isIndexMediaType(manifest.MediaType) {
+					continue
+				}
+				svcPlatforms[svc][platformName(manifest.Platform.OS, targetOS, manifest.Platform.Architecture, manifest.Platform.Variant)] = true
+				if !internal.OSMatches(manifest.Platform.OS, targetOS) {
+					continue
 				}
-				archToManifestList[manifest.Platform.Architecture][imageManifestSvc] = manifest.Digest
+				platform := internal.PlatformKey(manifest.Platform.Architecture, manifest.Platform.Variant)
+				if _, ok := archToManifestList[platform]; !ok {
+					archToManifestList[platform] = make(map[distribution.ManifestService]digest.Digest)
+				}
+				archToManifestList[platform][imageManifestSvc] = manifest.Digest
 			}
 		}
 
-		populateFromManifest := func(manifest *schema2.DeserializedManifest) error {
-			imageBlobSvc, err := GetBlobService(ctx, regClient, imageRef)
+		// a single-arch docker or OCI image manifest, its architecture is in the image config
+		populateFromManifest := func(configDesc distribution.Descriptor) error {
+			b, err := imageRepo.Blobs(ctx).Get(ctx, configDesc.Digest)
 			if err != nil {
 				return err
 			}
-			b, err := imageBlobSvc.Get(ctx, manifest.Config.Digest)
-			if err != nil {
-				return err
+			var config struct {
+				Architecture string `json:"architecture"`
+				Variant      string `json:"variant"`
+				OS           string `json:"os"`
 			}
-			config := make(map[string]interface{})
 			err = json.Unmarshal(b, &config)
 			if err != nil {
 				return err
 			}
-			arch := config["architecture"].(string)
+			if len(config.Architecture) == 0 {
+				return fmt.Errorf("image config doesn't specify an architecture; image: %s", imageRef.String())
+			}
+			svcPlatforms[svc][platformName(config.OS, targetOS, config.Architecture, config.Variant)] = true
+			if !internal.OSMatches(config.OS, targetOS) {
+				// the image has no manifest for the OS, so no architecture is supported by the App
+				return nil
+			}
+			arch := internal.PlatformKey(config.Architecture, config.Variant)
 			if _, ok := archToManifestList[arch]; !ok {
 				archToManifestList[arch] = make(map[distribution.ManifestService]digest.Digest)
 			}
@@ -126,49 +167,98 @@ func GetAppLayersFromMap(ctx context.Context, svcImages map[string]string, archL
 		case *manifestlist.DeserializedManifestList:
 			populateFromList(im)
 		case *schema2.DeserializedManifest:
-			err = populateFromManifest(im)
+			err = populateFromManifest(im.Config)
+			if err != nil {
+				return nil, err
+			}
+		case *ocischema.DeserializedManifest:
+			err = populateFromManifest(im.Config)
 			if err != nil {
 				return nil, err
 			}
 		default:
 			return nil, fmt.Errorf("unexpected type of image manifest; image: %s, type: %T", imageRef.(reference.Canonical).String(), indexManifest)
 		}
+
+		// an image may have several manifests for one platform, so the lock is
+		// checked once per platform against the manifest picked for it
+		platforms := make([]string, 0, len(archToManifestList))
+		for platform, manifests := range archToManifestList {
+			if _, ok := manifests[imageManifestSvc]; ok {
+				platforms = append(platforms, platform)
+			}
+		}
+		sort.Strings(platforms)
+		for _, platform := range platforms {
+			if err := lock.AddManifest(svc, platform, archToManifestList[platform][imageManifestSvc]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	svcs := make([]string, 0, len(svcPlatforms))
+	for svc := range svcPlatforms {
+		svcs = append(svcs, svc)
+	}
+	sort.Strings(svcs)
+	for _, svc := range svcs {
+		sink.Handle(events.ServicePlatforms{Service: svc, Platforms: sortedPlatforms(svcPlatforms[svc])})
 	}
 
 	appLayers := make(map[string]map[string]distribution.Descriptor)
 	expectedManNumber := len(svcImages)
+	// Shortlist architectures, we need to include only architectures for which there is one manifest per each service image
+	var supported []string
+	for arch, manifests := range archToManifestList {
+		if len(manifests) != expectedManNumber {
+			sink.Handle(events.ArchExcluded{
+				Arch:   arch,
+				Reason: fmt.Sprintf("some of the app images (%d images) don't have manifest for it", expectedManNumber-len(manifests)),
+			})
+			delete(archToManifestList, arch)
+			continue
+		}
+		supported = append(supported, arch)
+	}
+	sort.Strings(supported)
+
 	isInArchList := func(arch string) bool {
 		if len(archList) == 0 {
 			return true
 		}
 
 		for _, a := range archList {
-			if arch == a {
+			if internal.PlatformMatches(a, arch, supported) {
 				return true
 			}
 		}
 		return false
 	}
-	for arch, manifests := range archToManifestList {
-		// Shortlist architectures, we need to include only architectures for which there is one manifest per each service image
-		if len(manifests) != expectedManNumber {
-			fmt.Printf("  |-> exclude  %s architecture, some of the app images (%d images) don't have manifest for it\n", arch, expectedManNumber-len(manifests))
-			delete(archToManifestList, arch)
-			continue
-		}
-
+	var included []string
+	for _, arch := range supported {
 		if !isInArchList(arch) {
-			fmt.Printf("  |-> exclude  %s architecture since it's not in a list of the factory supported architectures: %q\n", arch, archList)
+			sink.Handle(events.ArchExcluded{
+				Arch:   arch,
+				Reason: fmt.Sprintf("it's not in a list of the factory supported architectures: %q", archList),
+			})
 			delete(archToManifestList, arch)
 			continue
 		}
+		included = append(included, arch)
+	}
+	if err := lock.SetArchitectures(included); err != nil {
+		return nil, err
+	}
 
-		fmt.Printf("  |-> getting app layers for architecture: %s\n", arch)
+	published := internal.PublishedPlatformKeys(included)
+	for _, arch := range included {
+		sink.Handle(events.ArchIncluded{Arch: arch, OS: targetOS, PublishedAs: published[arch]})
 
 		// we use map instead of slice/array of Descriptor in order to avoid layer duplication since
 		// different images can consists of the same layers (layer intersection across images)
-		appLayers[arch] = make(map[string]distribution.Descriptor)
-		for manSvc, d := range manifests {
+		// the layers are keyed by the platform key the App layers manifest is published with
+		appLayers[published[arch]] = make(map[string]distribution.Descriptor)
+		for manSvc, d := range archToManifestList[arch] {
 			manifest, err := manSvc.Get(ctx, d)
 			if err != nil {
 				return nil, err
@@ -183,7 +273,7 @@ func GetAppLayersFromMap(ctx context.Context, svcImages map[string]string, archL
 				return nil, fmt.Errorf("unsupport manifest type: %T", manifest)
 			}
 			for _, layer := range layers {
-				appLayers[arch][layer.Digest.Encoded()] = layer
+				appLayers[published[arch]][layer.Digest.Encoded()] = layer
 			}
 		}
 	}
@@ -209,10 +299,41 @@ func GetAppLayersFromMap(ctx context.Context, svcImages map[string]string, archL
 	return sortedAppLayers, nil
 }
 
-func ComposeAppLayersManifest(arch string, layers []distribution.Descriptor) (distribution.Manifest, *distribution.Descriptor, error) {
+// platformName returns the `os/arch[/variant]` name of a platform, it is of
+// the target OS if it doesn't specify its OS
+func platformName(os, targetOS, arch, variant string) string {
+	if len(os) == 0 {
+		os = targetOS
+	}
+	return internal.PlatformName(os, arch, variant)
+}
+
+func sortedPlatforms(platforms map[string]bool) []string {
+	sorted := make([]string, 0, len(platforms))
+	for p := range platforms {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func isIndexMediaType(mediaType string) bool {
+	return mediaType == manifestlist.MediaTypeManifestList || mediaType == v1.MediaTypeImageIndex
+}
+
+// ComposeAppLayersManifest composes the App layers manifest of a platform,
+// `arch` is a platform key as returned by internal.PublishedPlatformKeys. The
+// manifest platform OS is set to `platformOS` unless it's empty, devices with
+// a legacy aklite don't expect it.
+func ComposeAppLayersManifest(arch, platformOS string, layers []distribution.Descriptor) (distribution.Manifest, *distribution.Descriptor, error) {
+	architecture, variant := internal.ParsePlatformKey(arch)
 	platform := v1.Platform{
-		Architecture: arch,
-		// OS:           "LMP", make manifest a bit smaller until the updated aklite is installed on every device
+		Architecture: architecture,
+		Variant:      variant,
+	}
+	// the OS makes the App manifest a bit bigger, so it's omitted for devices with a legacy aklite
+	if len(platformOS) > 0 {
+		platform.OS = platformOS
 	}
 
 	manifestDef := struct {
@@ -243,7 +364,7 @@ func ComposeAppLayersManifest(arch string, layers []distribution.Descriptor) (di
 	return man, &desc, nil
 }
 
-func PostAppLayersManifests(ctx context.Context, appRef string, layers map[string][]distribution.Descriptor, dryRun bool) ([]distribution.Descriptor, error) {
+func PostAppLayersManifests(ctx context.Context, appRef string, layers map[string][]distribution.Descriptor, platformOS string, dryRun bool, sink events.Sink) ([]distribution.Descriptor, error) {
 	// sort layer lists by arch
 
 	manifestDescArchs := make([]string, len(layers))
@@ -270,14 +391,17 @@ func PostAppLayersManifests(ctx context.Context, appRef string, layers map[strin
 	ii = 0
 	manDescrs := make([]distribution.Descriptor, len(layers))
 	for _, arch := range manifestDescArchs {
-		manifest, desc, err := ComposeAppLayersManifest(arch, layers[arch])
+		manifest, desc, err := ComposeAppLayersManifest(arch, platformOS, layers[arch])
 		if err != nil {
 			return nil, err
 		}
 		if dryRun {
-			fmt.Printf("  |-> skipping layer manifest publishing for dryrun\n")
+			sink.Handle(events.LayerManifestPosted{Arch: arch, Descriptor: *desc, DryRun: true})
+		} else if exists, err := manSvc.Exists(ctx, desc.Digest); err != nil {
+			return nil, err
+		} else if exists {
+			sink.Handle(events.LayerManifestPosted{Arch: arch, Descriptor: *desc, Unchanged: true})
 		} else {
-			fmt.Printf("  |-> posting a layer manifest for architecture: %s...", arch)
 			digest, err := manSvc.Put(ctx, manifest)
 			if err != nil {
 				return nil, err
@@ -285,19 +409,51 @@ func PostAppLayersManifests(ctx context.Context, appRef string, layers map[strin
 			if digest.Encoded() != (*desc).Digest.Encoded() {
 				return nil, fmt.Errorf("digest of the posted manifest doesn't match to the composed manifest digest")
 			}
-			fmt.Printf("OK |-> digest: %s\n", digest)
-			manDescrs[ii] = *desc
+			sink.Handle(events.LayerManifestPosted{Arch: arch, Descriptor: *desc})
 		}
+		manDescrs[ii] = *desc
 		ii++
 	}
 	return manDescrs, nil
 }
 
-func GetAppLayersMeta(layersMetaFile string, appLayers map[string][]distribution.Descriptor) ([]byte, error) {
+// SaveAppLayersManifests writes the App layers manifests to an OCI image
+// layout instead of posting them to a registry
+func SaveAppLayersManifests(layout *internal.OCILayout, layers map[string][]distribution.Descriptor, platformOS string, sink events.Sink) ([]distribution.Descriptor, error) {
+	archs := make([]string, 0, len(layers))
+	for arch := range layers {
+		archs = append(archs, arch)
+	}
+	sort.Strings(archs)
+
+	manDescrs := make([]distribution.Descriptor, 0, len(layers))
+	for _, arch := range archs {
+		manifest, desc, err := ComposeAppLayersManifest(arch, platformOS, layers[arch])
+		if err != nil {
+			return nil, err
+		}
+		mediaType, payload, err := manifest.Payload()
+		if err != nil {
+			return nil, err
+		}
+		written, err := layout.WriteBlob(mediaType, payload)
+		if err != nil {
+			return nil, err
+		}
+		if written.Digest != desc.Digest {
+			return nil, fmt.Errorf("digest of the written manifest doesn't match to the composed manifest digest")
+		}
+		sink.Handle(events.LayerManifestPosted{Arch: arch, Descriptor: *desc, DryRun: true})
+		manDescrs = append(manDescrs, *desc)
+	}
+	return manDescrs, nil
+}
+
+func GetAppLayersMeta(layersMetaFile string, appLayers map[string][]distribution.Descriptor, sink events.Sink) ([]byte, error) {
 	var layersMeta LayersMeta
 	appLayersMeta := LayersMeta{}
 
-	fmt.Println("== Parsing all apps layers metadata...")
+	sink.Handle(events.StageStarted{Stage: "Parsing all apps layers metadata"})
 	if b, err := os.ReadFile(layersMetaFile); err == nil {
 		if err := json.Unmarshal(b, &layersMeta); err != nil {
 			return nil, err
@@ -306,23 +462,28 @@ func GetAppLayersMeta(layersMetaFile string, appLayers map[string][]distribution
 		return nil, err
 	}
 
-	fmt.Println("== Getting App layers metadata...")
+	sink.Handle(events.StageStarted{Stage: "Getting App layers metadata"})
 	for arch, layers := range appLayers {
-		if _, exists := layersMeta[arch]; !exists {
-			return nil, fmt.Errorf("no metadata about app layers of the given arch: %s", arch)
+		archMeta, exists := layersMeta[arch]
+		if !exists {
+			// the metadata may be collected per architecture regardless of its variant
+			bareArch, _ := internal.ParsePlatformKey(arch)
+			if archMeta, exists = layersMeta[bareArch]; !exists {
+				return nil, fmt.Errorf("no metadata about app layers of the given arch: %s", arch)
+			}
 		}
-		appLayersMeta[arch] = ArchLayersMeta{FsBlockSize: layersMeta[arch].FsBlockSize, Layers: map[digest.Digest]LayerMeta{}}
+		appLayersMeta[arch] = ArchLayersMeta{FsBlockSize: archMeta.FsBlockSize, Layers: map[digest.Digest]LayerMeta{}}
 		for _, l := range layers {
-			if _, exists := layersMeta[arch].Layers[l.Digest]; !exists {
+			if _, exists := archMeta.Layers[l.Digest]; !exists {
 				return nil, fmt.Errorf("app layer hasn't been built;"+
 					" one of the App images must have been changed since tagging and before pinning;"+
 					" layer digest: %s", l.Digest)
 			}
 			appLayersMeta[arch].Layers[l.Digest] = LayerMeta{
 				// Layer's diff size (diff = layer's part of rootfs )
-				Size:        layersMeta[arch].Layers[l.Digest].Size,
+				Size:        archMeta.Layers[l.Digest].Size,
 				// Disk usage by the layer's data (rootfs) and metadata
-				Usage:       layersMeta[arch].Layers[l.Digest].Usage,
+				Usage:       archMeta.Layers[l.Digest].Usage,
 				// Layer's archive/blob size
 				ArchiveSize: l.Size,
 			}
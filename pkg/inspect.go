@@ -0,0 +1,133 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/foundriesio/compose-publish/internal"
+	"github.com/foundriesio/compose-publish/pkg/fioapp"
+	"github.com/opencontainers/go-digest"
+)
+
+func DoInspect(target string) error {
+	ctx := context.Background()
+
+	app, err := internal.GetPublishedApp(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("= App: %s@%s\n", app.Ref.Name(), app.Digest)
+	fmt.Printf("  |-> manifest size: %d\n", len(app.Raw))
+
+	fmt.Println("= Annotations:")
+	for _, k := range sortedKeys(app.Manifest.Annotations) {
+		fmt.Printf("  |-> %s: %s\n", k, app.Manifest.Annotations[k])
+	}
+
+	bundle, err := app.BundleDescriptor()
+	if err != nil {
+		return err
+	}
+	fmt.Println("= App bundle:")
+	fmt.Printf("  |-> digest: %s\n", bundle.Digest)
+	fmt.Printf("  |-> size: %d\n", bundle.Size)
+
+	layersManifests, err := app.LayersManifests(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Println("= App layers manifests:")
+	if desc := app.Manifest.LayersIndexDescriptor(); desc != nil {
+		fmt.Printf("  |-> index: %s (%d bytes)\n", desc.Digest, desc.Size)
+	}
+	if len(layersManifests) == 0 {
+		fmt.Println("  |-> none")
+	}
+	for _, m := range layersManifests {
+		platform := "unknown"
+		if m.Platform != nil {
+			platform = m.Platform.Architecture
+			if len(m.Platform.Variant) > 0 {
+				platform += "/" + m.Platform.Variant
+			}
+		}
+		fmt.Printf("  |-> %s: %s (%d bytes)\n", platform, m.Digest, m.Size)
+	}
+
+	if desc := app.PlatformsDescriptor(); desc != nil {
+		b, err := app.FetchBlob(ctx, *desc)
+		if err != nil {
+			return err
+		}
+		var platforms internal.AppPlatforms
+		if err := json.Unmarshal(b, &platforms); err != nil {
+			return err
+		}
+		fmt.Println("= App platforms:")
+		fmt.Printf("  |-> supported: %s\n", strings.Join(platforms.Platforms, ", "))
+		for _, svc := range sortedServices(platforms.Services) {
+			fmt.Printf("  |-> %s: %s\n", svc, strings.Join(platforms.Services[svc], ", "))
+		}
+	}
+
+	metaDesc := app.LayersMetaDescriptor()
+	if metaDesc == nil {
+		fmt.Println("= App layers metadata: none")
+		return nil
+	}
+	fmt.Println("= App layers metadata:")
+	fmt.Printf("  |-> digest: %s\n", metaDesc.Digest)
+	fmt.Printf("  |-> size: %d\n", metaDesc.Size)
+	for _, k := range sortedKeys(metaDesc.Annotations) {
+		fmt.Printf("  |-> %s: %s\n", k, metaDesc.Annotations[k])
+	}
+	b, err := app.FetchBlob(ctx, *metaDesc)
+	if err != nil {
+		return err
+	}
+	var layersMeta fioapp.LayersMeta
+	if err := json.Unmarshal(b, &layersMeta); err != nil {
+		return fmt.Errorf("failed to decode App layers metadata: %s", err.Error())
+	}
+	archs := make([]string, 0, len(layersMeta))
+	for arch := range layersMeta {
+		archs = append(archs, arch)
+	}
+	sort.Strings(archs)
+	for _, arch := range archs {
+		archMeta := layersMeta[arch]
+		fmt.Printf("  |-> arch: %s, fs block size: %d\n", arch, archMeta.FsBlockSize)
+		layers := make([]string, 0, len(archMeta.Layers))
+		for d := range archMeta.Layers {
+			layers = append(layers, d.String())
+		}
+		sort.Strings(layers)
+		for _, l := range layers {
+			lm := archMeta.Layers[digest.Digest(l)]
+			fmt.Printf("     |-> %s: size: %d, usage: %d, archive size: %d\n", l, lm.Size, lm.Usage, lm.ArchiveSize)
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedServices(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
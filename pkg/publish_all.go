@@ -0,0 +1,171 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/foundriesio/compose-publish/pkg/events"
+)
+
+// BatchOptions defines how to publish all Apps found in a directory
+type BatchOptions struct {
+	// AppsDir contains App directories, each of them with a docker-compose.yml
+	AppsDir string
+	// TargetTemplate is a template of the Apps' targets, `{app}` is replaced
+	// with an App directory name, and `{tag}` with Tag
+	TargetTemplate string
+	Tag            string
+	// Concurrency is the maximum number of Apps published at the same time
+	Concurrency int
+	// Options are applied to each App, except of its directory, compose
+	// files and target; if LayoutDir is set each App is written to its
+	// subdirectory named after the App
+	Options PublishOptions
+}
+
+// BatchResult is a result of publishing one of the Apps
+type BatchResult struct {
+	App    string
+	Target string
+	Report *PublishReport
+	Err    error
+}
+
+// FindApps returns names of the App directories, i.e. subdirectories that
+// contain a docker-compose.yml
+func FindApps(appsDir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(appsDir)
+	if err != nil {
+		return nil, err
+	}
+	var apps []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(appsDir, e.Name(), "docker-compose.yml")); err == nil {
+			apps = append(apps, e.Name())
+		}
+	}
+	sort.Strings(apps)
+	return apps, nil
+}
+
+func (o BatchOptions) target(app string) (string, error) {
+	if strings.Contains(o.TargetTemplate, "{tag}") && len(o.Tag) == 0 {
+		return "", errors.New("a tag is required by the target template " + o.TargetTemplate)
+	}
+	return strings.NewReplacer("{app}", app, "{tag}", o.Tag).Replace(o.TargetTemplate), nil
+}
+
+// PublishAll publishes all Apps found in the Apps directory, up to the given
+// number of them at the same time. Progress of each App is buffered and
+// printed once the App is done, so output of different Apps doesn't mix.
+func PublishAll(ctx context.Context, opts BatchOptions) ([]BatchResult, error) {
+	apps, err := FindApps(opts.AppsDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(apps) == 0 {
+		return nil, fmt.Errorf("no apps found in %s", opts.AppsDir)
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]BatchResult, len(apps))
+	for i, app := range apps {
+		target, err := opts.target(app)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = BatchResult{App: app, Target: target}
+	}
+
+	// the Apps are published concurrently, so events are forwarded to the
+	// caller's sink one at a time
+	var callerSink events.Sink
+	if opts.Options.Events != nil {
+		var sinkMu sync.Mutex
+		callerSink = events.SinkFunc(func(event events.Event) {
+			sinkMu.Lock()
+			defer sinkMu.Unlock()
+			opts.Options.Events.Handle(event)
+		})
+	}
+
+	var outMu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for i := range results {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(res *BatchResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			var out bytes.Buffer
+			appOpts := opts.Options
+			appOpts.ProjectDir = filepath.Join(opts.AppsDir, res.App)
+			appOpts.ComposeFiles = nil
+			appOpts.Target = res.Target
+			// the Apps would overwrite each other's bundle file
+			appOpts.BundleFile = ""
+			appOpts.Events = events.Multi{&events.Console{Out: &out}, callerSink}
+			appOpts.Report = NewPublishReport(res.Target, appOpts.DryRun)
+			if len(appOpts.LayoutDir) > 0 {
+				appOpts.LayoutDir = filepath.Join(opts.Options.LayoutDir, res.App)
+			}
+			res.Report = appOpts.Report
+			_, res.Err = Publish(ctx, appOpts)
+
+			outMu.Lock()
+			defer outMu.Unlock()
+			fmt.Printf("= Publishing app %s to %s...\n", res.App, res.Target)
+			os.Stdout.Write(out.Bytes())
+		}(&results[i])
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// DoPublishAll publishes all Apps found in a directory and prints a summary,
+// it fails if any of the Apps fails to be published
+func DoPublishAll(opts BatchOptions) error {
+	results, err := PublishAll(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("= Summary:")
+	failed := 0
+	for _, res := range results {
+		if res.Err != nil {
+			failed++
+			fmt.Printf("  |-> FAIL %s: %s\n", res.App, res.Err)
+			continue
+		}
+		var dgst string
+		if m := res.Report.Manifest; m != nil {
+			dgst = m.Digest.String()
+		}
+		if res.Report.Unchanged {
+			dgst += " (unchanged)"
+		}
+		fmt.Printf("  |-> OK   %s: %s@%s\n", res.App, res.Target, dgst)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d apps failed to be published", failed, len(results))
+	}
+	return nil
+}
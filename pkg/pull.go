@@ -0,0 +1,34 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/foundriesio/compose-publish/internal"
+)
+
+func DoPull(target, dstDir string) error {
+	ctx := context.Background()
+
+	fmt.Printf("= Fetching app manifest %s...\n", target)
+	app, err := internal.GetPublishedApp(ctx, target)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  |-> manifest: %s\n", app.Digest)
+
+	bundleDesc, err := app.BundleDescriptor()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("= Fetching app bundle...")
+	bundle, err := app.FetchBlob(ctx, bundleDesc)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  |-> app blob: %s (%d bytes)\n", bundleDesc.Digest, bundleDesc.Size)
+
+	fmt.Printf("= Extracting app bundle to %s...\n", dstDir)
+	return internal.ExtractBundle(bundle, dstDir)
+}
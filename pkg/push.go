@@ -0,0 +1,115 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/foundriesio/compose-publish/internal"
+	"github.com/foundriesio/compose-publish/pkg/events"
+)
+
+// getLayoutApp returns the descriptor of the App manifest referenced by
+// an OCI image layout index along with the manifest itself
+func getLayoutApp(layout *internal.OCILayout) (*v1.Descriptor, *internal.AppManifest, []byte, error) {
+	index, err := layout.ReadIndex()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, desc := range index.Manifests {
+		if desc.MediaType != v1.MediaTypeImageManifest {
+			continue
+		}
+		raw, err := layout.ReadBlob(distribution.Descriptor{Digest: desc.Digest, Size: desc.Size})
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		var man internal.AppManifest
+		if err := json.Unmarshal(raw, &man); err != nil {
+			return nil, nil, nil, err
+		}
+		if man.Annotations["compose-app"] == "v1" {
+			return &desc, &man, raw, nil
+		}
+	}
+	return nil, nil, nil, fmt.Errorf("%s doesn't contain a compose app manifest", layout.Dir)
+}
+
+// getLayoutTags returns the tags the App manifest is recorded with in the
+// layout index, none if the App is published by digest only
+func getLayoutTags(layout *internal.OCILayout, dgst digest.Digest) ([]string, error) {
+	index, err := layout.ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for _, desc := range index.Manifests {
+		if tag, ok := desc.Annotations[v1.AnnotationRefName]; ok && desc.Digest == dgst {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// DoPush publishes an App written to an OCI image layout by a dry run. The
+// App is tagged with the target's tag, or the tags recorded in the layout if
+// the target has no tag. The blobs and manifests that are already in the
+// repository are not uploaded again, so a push can be safely repeated.
+func DoPush(layoutDir, target, digestFile string, sink events.Sink) error {
+	if sink == nil {
+		sink = &events.Console{}
+	}
+	named, err := reference.ParseNormalizedNamed(target)
+	if err != nil {
+		return err
+	}
+	layout, err := internal.OpenOCILayout(layoutDir)
+	if err != nil {
+		return err
+	}
+
+	sink.Handle(events.StageStarted{Stage: "Reading app from " + layoutDir})
+	appDesc, app, raw, err := getLayoutApp(layout)
+	if err != nil {
+		return err
+	}
+	if digested, ok := named.(reference.Digested); ok && digested.Digest() != appDesc.Digest {
+		return fmt.Errorf("app manifest digest mismatch; expected: %s, got: %s", digested.Digest(), appDesc.Digest)
+	}
+	tags, err := getLayoutTags(layout, appDesc.Digest)
+	if err != nil {
+		return err
+	}
+	if tagged, ok := named.(reference.Tagged); ok {
+		tags = []string{tagged.Tag()}
+	}
+
+	layersManifests := app.Manifests
+	if indexDesc := app.LayersIndexDescriptor(); indexDesc != nil {
+		b, err := layout.ReadBlob(*indexDesc)
+		if err != nil {
+			return err
+		}
+		if layersManifests, err = internal.ParseLayersIndex(b); err != nil {
+			return err
+		}
+	}
+
+	dgst, err := internal.PushSavedApp(context.Background(), layout, raw, layersManifests, target, tags, sink)
+	if err != nil {
+		return err
+	}
+	if dgst != appDesc.Digest.String() {
+		return fmt.Errorf("digest of the pushed app manifest doesn't match; expected: %s, got: %s", appDesc.Digest, dgst)
+	}
+	if len(digestFile) > 0 {
+		return ioutil.WriteFile(digestFile, []byte(dgst), 0o640)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/compose-spec/compose-go/loader"
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/foundriesio/compose-publish/internal"
+)
+
+type verifier struct {
+	failures int
+}
+
+func (v *verifier) check(item string, err error) bool {
+	if err != nil {
+		v.failures++
+		fmt.Printf("  |-> FAIL %s: %s\n", item, err)
+		return false
+	}
+	fmt.Printf("  |-> OK   %s\n", item)
+	return true
+}
+
+func getVerifiedManifest(ctx context.Context, mansvc distribution.ManifestService, dgst digest.Digest) (distribution.Manifest, error) {
+	man, err := mansvc.Get(ctx, dgst)
+	if err != nil {
+		return nil, err
+	}
+	_, payload, err := man.Payload()
+	if err != nil {
+		return nil, err
+	}
+	if d := digest.FromBytes(payload); d != dgst {
+		return nil, fmt.Errorf("digest mismatch; expected: %s, got: %s", dgst, d)
+	}
+	return man, nil
+}
+
+func statBlob(ctx context.Context, blobs distribution.BlobStatter, desc distribution.Descriptor) error {
+	d, err := blobs.Stat(ctx, desc.Digest)
+	if err != nil {
+		return err
+	}
+	if d.Size != desc.Size {
+		return fmt.Errorf("size mismatch; expected: %d, got: %d", desc.Size, d.Size)
+	}
+	return nil
+}
+
+// verifyServiceImage checks that a pinned service image and all manifests it
+// refers to are fetchable, it returns blob stores of the image repo keyed by
+// digests of the image layers
+func (v *verifier) verifyServiceImage(ctx context.Context, regc internal.RegistryClient, svc, image string) map[digest.Digest]distribution.BlobStore {
+	layerStores := make(map[digest.Digest]distribution.BlobStore)
+	item := fmt.Sprintf("service %s image %s", svc, image)
+
+	named, err := reference.ParseNormalizedNamed(image)
+	if !v.check(item, err) {
+		return layerStores
+	}
+	digested, ok := named.(reference.Digested)
+	if !ok {
+		v.check(item, fmt.Errorf("image is not pinned to a digest"))
+		return layerStores
+	}
+	repo, err := regc.GetRepository(ctx, named)
+	if !v.check(item, err) {
+		return layerStores
+	}
+	mansvc, err := repo.Manifests(ctx, nil)
+	if !v.check(item, err) {
+		return layerStores
+	}
+	man, err := getVerifiedManifest(ctx, mansvc, digested.Digest())
+	if !v.check(item, err) {
+		return layerStores
+	}
+
+	var manifests []distribution.Manifest
+	switch m := man.(type) {
+	case *manifestlist.DeserializedManifestList:
+		for _, desc := range m.Manifests {
+			platformMan, err := getVerifiedManifest(ctx, mansvc, desc.Digest)
+			if v.check(fmt.Sprintf("service %s image %s manifest %s", svc, desc.Platform.Architecture, desc.Digest), err) {
+				manifests = append(manifests, platformMan)
+			}
+		}
+	default:
+		manifests = append(manifests, man)
+	}
+
+	for _, m := range manifests {
+		var layers []distribution.Descriptor
+		switch im := m.(type) {
+		case *schema2.DeserializedManifest:
+			layers = im.Layers
+		case *ocischema.DeserializedManifest:
+			layers = im.Layers
+		}
+		for _, l := range layers {
+			layerStores[l.Digest] = repo.Blobs(ctx)
+		}
+	}
+	return layerStores
+}
+
+func DoVerify(target string) error {
+	ctx := context.Background()
+	v := verifier{}
+
+	fmt.Printf("= Verifying app %s...\n", target)
+	app, err := internal.GetPublishedApp(ctx, target)
+	if !v.check("app manifest", err) {
+		return fmt.Errorf("app verification failed")
+	}
+
+	bundleDesc, err := app.BundleDescriptor()
+	if !v.check("app bundle descriptor", err) {
+		return fmt.Errorf("app verification failed")
+	}
+	bundle, err := app.FetchBlob(ctx, bundleDesc)
+	if !v.check(fmt.Sprintf("app bundle %s", bundleDesc.Digest), err) {
+		return fmt.Errorf("app verification failed")
+	}
+
+	if metaDesc := app.LayersMetaDescriptor(); metaDesc != nil {
+		_, err := app.FetchBlob(ctx, *metaDesc)
+		v.check(fmt.Sprintf("app layers meta %s", metaDesc.Digest), err)
+	}
+
+	if platformsDesc := app.PlatformsDescriptor(); platformsDesc != nil {
+		b, err := app.FetchBlob(ctx, *platformsDesc)
+		if err == nil {
+			var platforms internal.AppPlatforms
+			err = json.Unmarshal(b, &platforms)
+		}
+		v.check(fmt.Sprintf("app platforms %s", platformsDesc.Digest), err)
+	}
+
+	fmt.Println("= Verifying service images...")
+	files, err := internal.ReadBundle(bundle)
+	if !v.check("app bundle content", err) {
+		return fmt.Errorf("app verification failed")
+	}
+	config, err := loader.ParseYAML(files["docker-compose.yml"])
+	if !v.check("app compose file", err) {
+		return fmt.Errorf("app verification failed")
+	}
+	svcs, _ := config["services"].(map[string]interface{})
+	regc := internal.NewRegistryClient()
+	layerStores := make(map[digest.Digest]distribution.BlobStore)
+	for _, name := range sortedMapKeys(svcs) {
+		svc, _ := svcs[name].(map[string]interface{})
+		for d, store := range v.verifyServiceImage(ctx, regc, name, serviceImage(svc)) {
+			layerStores[d] = store
+		}
+	}
+
+	fmt.Println("= Verifying app layers manifests...")
+	layersManifests, err := app.LayersManifests(ctx)
+	v.check("app layers manifests list", err)
+	for _, desc := range layersManifests {
+		layersMan, err := app.FetchLayersManifest(ctx, desc)
+		if !v.check(fmt.Sprintf("app layers manifest %s", desc.Digest), err) {
+			continue
+		}
+		for _, l := range layersMan.Layers {
+			item := fmt.Sprintf("layer %s", l.Digest)
+			store, ok := layerStores[l.Digest]
+			if !ok {
+				v.check(item, fmt.Errorf("layer is not referenced by any of the service images"))
+				continue
+			}
+			v.check(item, statBlob(ctx, store, l))
+		}
+	}
+
+	if v.failures > 0 {
+		return fmt.Errorf("app verification failed, found %d problem(s)", v.failures)
+	}
+	fmt.Println("= App is valid")
+	return nil
+}